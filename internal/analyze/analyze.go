@@ -1,116 +1,341 @@
 package analyze
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
-	"sort"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/go-echarts/go-echarts/v2/charts"
 	"github.com/go-echarts/go-echarts/v2/opts"
-	"gonum.org/v1/gonum/floats"
-	"gonum.org/v1/gonum/stat"
+	"github.com/go-echarts/go-echarts/v2/render"
+	"github.com/go-logr/logr"
 
 	"github.com/spegel-org/benchmark/internal/measure"
 )
 
-func Analyze(ctx context.Context, suitePaths []string, outputDir string) error {
+// Analyze creates charts, summary statistics and a report comparing the suites.
+func Analyze(ctx context.Context, suitePaths []string, outputDir, keyMode, imageSizeSource string) error {
+	log := logr.FromContextOrDiscard(ctx)
+
+	sizes, err := newImageSizes(imageSizeSource)
+	if err != nil {
+		return err
+	}
+
+	// Suites are identified by name in the charts and summaries, so names have to be unique.
 	suites := []measure.Suite{}
+	suitePathsByName := map[string]string{}
 	for _, path := range suitePaths {
-		b, err := os.ReadFile(path)
+		suite, err := loadSuite(path)
 		if err != nil {
 			return err
 		}
-		suite := measure.Suite{}
-		err = json.Unmarshal(b, &suite)
-		if err != nil {
-			return err
+		if otherPath, ok := suitePathsByName[suite.Name]; ok {
+			return fmt.Errorf("suites %s and %s have the same name %s", otherPath, path, suite.Name)
 		}
+		suitePathsByName[suite.Name] = path
 		suites = append(suites, suite)
 	}
 	if len(suites) == 0 {
 		return errors.New("suites is empty")
 	}
 
-	err := os.MkdirAll(outputDir, 0o755)
+	err = os.MkdirAll(outputDir, 0o755)
 	if err != nil {
 		return err
 	}
 
-	for k := range suites[0].Benchmarks {
-		benchmarks := []measure.Benchmark{}
-		suiteNames := []string{}
-		for j := range suites {
-			benchmarks = append(benchmarks, suites[j].Benchmarks[k])
-			suiteNames = append(suiteNames, suites[j].Name)
+	keys, missing, err := benchmarkKeys(suites, keyMode)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return errors.New("no benchmarks to compare")
+	}
+	suiteNames := []string{}
+	environments := []string{}
+	for _, suite := range suites {
+		suiteNames = append(suiteNames, suite.Name)
+		environments = append(environments, fmt.Sprintf("%s: %s", suite.Name, suiteEnvironment(suite)))
+		if len(missing[suite.Name]) > 0 {
+			log.Info("suite is missing benchmarks", "suite", suite.Name, "benchmarks", missing[suite.Name])
 		}
-		err := createBoxPlot(benchmarks, suiteNames, outputDir, k)
+	}
+	rep := report{}
+	for _, suite := range suites {
+		rep.Suites = append(rep.Suites, reportSuite{Name: suite.Name, Environment: newEnvironment(suite)})
+	}
+	allSummaries := []BenchmarkSummary{}
+	allSignificance := []Significance{}
+	for _, k := range keys {
+		summaries := []BenchmarkSummary{}
+		for _, suite := range suites {
+			benchmark, ok := suite.Benchmarks[k]
+			if !ok {
+				continue
+			}
+			summary := summarizeBenchmark(suite.Name, k, benchmark)
+			err := summarizeThroughput(ctx, sizes, &summary, benchmark)
+			if err != nil {
+				return err
+			}
+			summaries = append(summaries, summary)
+		}
+		reportCharts, err := writeBenchmarkCharts(suites, summaries, suiteNames, environments, outputDir, k)
 		if err != nil {
 			return err
 		}
+		allSummaries = append(allSummaries, summaries...)
+		allSignificance = append(allSignificance, compareSuites(k, suites)...)
+		rep.Benchmarks = append(rep.Benchmarks, reportBenchmark{
+			Name:      k,
+			Summaries: summaries,
+			Charts:    reportCharts,
+		})
+	}
+	err = writeJSON(filepath.Join(outputDir, "summary.json"), allSummaries)
+	if err != nil {
+		return err
+	}
+	err = writeJSON(filepath.Join(outputDir, "significance.json"), allSignificance)
+	if err != nil {
+		return err
+	}
+	err = writeReport(outputDir, rep)
+	if err != nil {
+		return err
 	}
 	return nil
 }
 
-func createBoxPlot(benchmarks []measure.Benchmark, suiteNames []string, outputDir, benchmarkName string) error {
+func loadSuite(path string) (measure.Suite, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return measure.Suite{}, err
+	}
+	suite := measure.Suite{}
+	err = json.Unmarshal(b, &suite)
+	if err != nil {
+		return measure.Suite{}, err
+	}
+	return suite, nil
+}
+
+func writeJSON(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
+
+// writeBenchmarkCharts writes the box plots of the benchmark followed by the charts of each phase.
+func writeBenchmarkCharts(suites []measure.Suite, summaries []BenchmarkSummary, suiteNames, environments []string, outputDir, benchmarkName string) ([]reportChart, error) {
+	reportCharts := []reportChart{}
+	write := func(c chart, name string) error {
+		snippet, err := writeChart(c, outputDir, name)
+		if err != nil {
+			return err
+		}
+		reportCharts = append(reportCharts, newReportChart(snippet))
+		return nil
+	}
+
+	err := write(newBoxPlot(summaries, suiteNames, environments, benchmarkName, "Duration (seconds)"), benchmarkName)
+	if err != nil {
+		return nil, err
+	}
+	throughput := throughputSummaries(summaries)
+	if len(throughput) > 0 {
+		title, yLabel := benchmarkName+" throughput", "Throughput (MB/s)"
+		if isNominalThroughput(throughput) {
+			title, yLabel = benchmarkName+" nominal throughput", "Nominal throughput (MB/s)"
+		}
+		err := write(newBoxPlot(throughput, suiteNames, environments, title, yLabel), benchmarkName+"-throughput")
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, phase := range []string{"create", "update"} {
+		if cdf, ok := newCDF(suites, suiteNames, benchmarkName, phase); ok {
+			err := write(cdf, fmt.Sprintf("%s-%s-cdf", benchmarkName, phase))
+			if err != nil {
+				return nil, err
+			}
+		}
+		if histogram, ok := newHistogram(suites, suiteNames, benchmarkName, phase); ok {
+			err := write(histogram, fmt.Sprintf("%s-%s-histogram", benchmarkName, phase))
+			if err != nil {
+				return nil, err
+			}
+		}
+		if timeline, ok := newTimeline(suites, suiteNames, benchmarkName, phase); ok {
+			err := write(timeline, fmt.Sprintf("%s-%s-timeline", benchmarkName, phase))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return reportCharts, nil
+}
+
+type chart interface {
+	Render(w io.Writer) error
+	RenderSnippet() render.ChartSnippet
+}
+
+// writeChart writes the chart page and options, and returns the snippet embedded in the report.
+func writeChart(c chart, outputDir, name string) (render.ChartSnippet, error) {
+	snippet := c.RenderSnippet()
+	err := os.WriteFile(filepath.Join(outputDir, name+".json"), []byte(snippet.Option), 0o644)
+	if err != nil {
+		return render.ChartSnippet{}, err
+	}
+	file, err := os.Create(filepath.Join(outputDir, name+".html"))
+	if err != nil {
+		return render.ChartSnippet{}, err
+	}
+	defer file.Close()
+	err = c.Render(file)
+	if err != nil {
+		return render.ChartSnippet{}, err
+	}
+	return snippet, nil
+}
+
+// newBoxPlot colors the suites by index so that colors are the same across charts.
+func newBoxPlot(summaries []BenchmarkSummary, suiteNames, environments []string, title, yAxisName string) *charts.BoxPlot {
 	bp := charts.NewBoxPlot()
 	bp.SetGlobalOptions(
-		charts.WithYAxisOpts(opts.YAxis{Name: "Duration (seconds)", NameLocation: "middle", NameGap: 40}),
-		charts.WithLegendOpts(opts.Legend{Left: "70%"}),
+		charts.WithTitleOpts(opts.Title{Title: title, Subtitle: strings.Join(environments, "\n")}),
+		charts.WithGridOpts(opts.Grid{Top: strconv.Itoa(60 + len(environments)*20), Bottom: "60"}),
+		charts.WithYAxisOpts(opts.YAxis{Name: yAxisName, NameLocation: "middle", NameGap: 40}),
+		charts.WithLegendOpts(opts.Legend{Type: "scroll", Left: "center", Bottom: "0"}),
 		charts.WithAnimation(false),
 	)
 	bp.SetXAxis([]string{"Create", "Update"})
 
-	itemStyles := []opts.ItemStyle{
-		{BorderColor: "#164577", Color: "#9CC1E3"},
-		{BorderColor: "#FAA93B", Color: "#FAEAD4"},
-	}
-	for i, v := range benchmarks {
-		initialDurations := []float64{}
-		for _, sample := range v.Create.Samples {
-			initialDurations = append(initialDurations, sample.Duration.Seconds())
+	for _, v := range summaries {
+		data := []opts.BoxPlotData{
+			{Value: createBoxPlotData(v.Create), Name: v.Suite},
+			{Value: createBoxPlotData(v.Update), Name: v.Suite},
 		}
+		bp.AddSeries(v.Suite, data, charts.WithItemStyleOpts(itemStyle(slices.Index(suiteNames, v.Suite))))
+	}
+	return bp
+}
 
-		rollingDurations := []float64{}
-		for _, sample := range v.Update.Samples {
-			rollingDurations = append(rollingDurations, sample.Duration.Seconds())
-		}
+// environment is the cluster and node environment a suite was run in.
+type environment struct {
+	KubernetesVersion string
+	CNI               string
+	// Spegel is empty for suites recorded before Spegel was detected.
+	Spegel       string
+	Runtime      string
+	Kernel       string
+	OSImage      string
+	Platform     string
+	InstanceType string
+	Zone         string
+	NodeCount    int
+}
 
-		data := []opts.BoxPlotData{
-			{Value: createBoxPlotData(initialDurations), Name: suiteNames[i]},
-			{Value: createBoxPlotData(rollingDurations), Name: suiteNames[i]},
+func newEnvironment(suite measure.Suite) environment {
+	runtimes := []string{}
+	kernels := []string{}
+	osImages := []string{}
+	platforms := []string{}
+	instanceTypes := []string{}
+	zones := []string{}
+	for _, node := range suite.Nodes {
+		runtimes = append(runtimes, node.ContainerRuntimeVersion)
+		kernels = append(kernels, node.KernelVersion)
+		osImages = append(osImages, node.OSImage)
+		if node.OperatingSystem != "" && node.Architecture != "" {
+			platforms = append(platforms, fmt.Sprintf("%s/%s", node.OperatingSystem, node.Architecture))
 		}
-		bp.AddSeries(suiteNames[i], data, charts.WithItemStyleOpts(itemStyles[i]))
+		instanceTypes = append(instanceTypes, node.InstanceType)
+		zones = append(zones, node.Zone)
+	}
+	env := environment{
+		KubernetesVersion: suite.KubernetesVersion,
+		CNI:               suite.Cluster.CNI,
+		Runtime:           uniqueValues(runtimes),
+		Kernel:            uniqueValues(kernels),
+		OSImage:           uniqueValues(osImages),
+		Platform:          uniqueValues(platforms),
+		InstanceType:      uniqueValues(instanceTypes),
+		Zone:              uniqueValues(zones),
+		NodeCount:         suite.Cluster.NodeCount,
+	}
+	if env.NodeCount == 0 {
+		env.NodeCount = len(suite.Nodes)
 	}
+	switch {
+	case suite.Spegel == nil:
+	case suite.Spegel.Detected:
+		env.Spegel = cmp.Or(suite.Spegel.Version, "unknown")
+	default:
+		env.Spegel = spegelNone
+	}
+	return env
+}
 
-	snippet := bp.RenderSnippet()
-	err := os.WriteFile(filepath.Join(outputDir, benchmarkName+".json"), []byte(snippet.Option), 0o644)
-	if err != nil {
-		return err
+func suiteEnvironment(suite measure.Suite) string {
+	env := newEnvironment(suite)
+	parts := []string{
+		fmt.Sprintf("Kubernetes %s", env.KubernetesVersion),
+		fmt.Sprintf("%d nodes", env.NodeCount),
 	}
-	file, err := os.Create(filepath.Join(outputDir, benchmarkName+".html"))
-	if err != nil {
-		return err
+	if env.CNI != "" {
+		parts = append(parts, fmt.Sprintf("CNI %s", env.CNI))
 	}
-	err = bp.Render(file)
-	if err != nil {
-		return err
+	switch env.Spegel {
+	case "":
+	case spegelNone:
+		parts = append(parts, "no Spegel")
+	default:
+		parts = append(parts, fmt.Sprintf("Spegel %s", env.Spegel))
 	}
-	return nil
+	for _, v := range []string{env.Runtime, env.Kernel, env.OSImage, env.Platform, env.InstanceType, env.Zone} {
+		if v == "" {
+			continue
+		}
+		parts = append(parts, v)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// uniqueValues joins the sorted unique non empty values.
+func uniqueValues(values []string) string {
+	unique := []string{}
+	for _, v := range values {
+		if v == "" || slices.Contains(unique, v) {
+			continue
+		}
+		unique = append(unique, v)
+	}
+	slices.Sort(unique)
+	return strings.Join(unique, "|")
 }
 
-func createBoxPlotData(data []float64) []float64 {
-	if len(data) == 0 {
+func createBoxPlotData(summary Summary) []float64 {
+	if summary.Count == 0 {
 		return nil
 	}
-	sort.Float64s(data)
 	return []float64{
-		floats.Min(data),
-		stat.Quantile(0.25, stat.Empirical, data, nil),
-		stat.Mean(data, nil),
-		stat.Quantile(0.75, stat.Empirical, data, nil),
-		floats.Max(data),
+		summary.Min,
+		summary.P25,
+		summary.Median,
+		summary.P75,
+		summary.Max,
 	}
 }
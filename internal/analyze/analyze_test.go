@@ -1,16 +1,586 @@
 package analyze
 
 import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-echarts/go-echarts/v2/opts"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/parquet-go/parquet-go"
 	"github.com/stretchr/testify/require"
+
+	"github.com/spegel-org/benchmark/internal/measure"
 )
 
 func TestCreateBoxPlotData(t *testing.T) {
 	t.Parallel()
 
-	data := createBoxPlotData(nil)
+	data := createBoxPlotData(summarize(nil))
 	require.Empty(t, data)
-	data = createBoxPlotData([]float64{0, 2, 1, 3})
-	require.Equal(t, []float64{0, 0, 1.5, 2, 3}, data)
+	data = createBoxPlotData(summarize([]float64{0, 2, 1, 3}))
+	require.Equal(t, []float64{0, 0.75, 1.5, 2.25, 3}, data)
+}
+
+func TestSummarize(t *testing.T) {
+	t.Parallel()
+
+	data := []float64{}
+	for i := range 101 {
+		data = append(data, float64(100-i))
+	}
+	summary := summarize(data)
+	expected := Summary{
+		Count:  101,
+		Min:    0,
+		P25:    25,
+		Median: 50,
+		P75:    75,
+		P90:    90,
+		P95:    95,
+		P99:    99,
+		Max:    100,
+		Mean:   50,
+		StdDev: 29.300170647967224,
+	}
+	require.InDelta(t, expected.StdDev, summary.StdDev, 1e-9)
+	summary.StdDev = expected.StdDev
+	require.Equal(t, expected, summary)
+	require.InDelta(t, 100.0, data[0], 0, "input should not be sorted in place")
+
+	require.Equal(t, Summary{Count: 1, Min: 2, P25: 2, Median: 2, P75: 2, P90: 2, P95: 2, P99: 2, Max: 2, Mean: 2}, summarize([]float64{2}))
+	require.Equal(t, Summary{}, summarize(nil))
+}
+
+func TestSuiteEnvironment(t *testing.T) {
+	t.Parallel()
+
+	suite := measure.Suite{
+		KubernetesVersion: "v1.31.0",
+		Cluster:           measure.Cluster{CNI: "cilium", NodeCount: 3},
+		Spegel:            &measure.Spegel{Detected: true, Version: "v0.0.30"},
+		Nodes: []measure.Node{
+			{Name: "a", ContainerRuntimeVersion: "containerd://1.7.20", OperatingSystem: "linux", Architecture: "amd64", Zone: "eu-west-1a"},
+			{Name: "b", ContainerRuntimeVersion: "containerd://1.7.20", OperatingSystem: "linux", Architecture: "amd64", Zone: "eu-west-1b"},
+		},
+	}
+	env := suiteEnvironment(suite)
+	require.Equal(t, "Kubernetes v1.31.0, 3 nodes, CNI cilium, Spegel v0.0.30, containerd://1.7.20, linux/amd64, eu-west-1a|eu-west-1b", env)
+}
+
+func TestItemStyle(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, basePalette[0], itemStyle(0))
+	require.Equal(t, basePalette[1], itemStyle(1))
+	colors := map[string]struct{}{}
+	for i := range 12 {
+		style := itemStyle(i)
+		require.Regexp(t, "^#[0-9A-F]{6}$", style.Color)
+		require.Regexp(t, "^#[0-9A-F]{6}$", style.BorderColor)
+		colors[style.Color] = struct{}{}
+	}
+	require.Len(t, colors, 12)
+}
+
+func TestHSLToHex(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "#FF0000", hslToHex(0, 1, 0.5))
+	require.Equal(t, "#008000", hslToHex(120, 1, 0.25))
+	require.Equal(t, "#0000FF", hslToHex(240, 1, 0.5))
+	require.Equal(t, "#FFFFFF", hslToHex(0, 0, 1))
+}
+
+func TestAnalyzeMultipleSuites(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	suitePaths := []string{}
+	for i, name := range []string{"baseline", "spegel-v0.2", "spegel-v0.3"} {
+		suite := measure.Suite{
+			Name: name,
+			Benchmarks: map[string]measure.Benchmark{
+				"10MB-1": {
+					Create: measure.Measurement{Samples: []measure.Sample{{Duration: time.Duration(i+1) * time.Second}}},
+					Update: measure.Measurement{Samples: []measure.Sample{{Duration: time.Duration(i+2) * time.Second}}},
+				},
+			},
+		}
+		b, err := json.Marshal(suite)
+		require.NoError(t, err)
+		path := filepath.Join(dir, name+".json")
+		err = os.WriteFile(path, b, 0o644)
+		require.NoError(t, err)
+		suitePaths = append(suitePaths, path)
+	}
+	outputDir := filepath.Join(dir, "output")
+	err := Analyze(t.Context(), suitePaths, outputDir, KeysUnion, ImageSizeFromKey)
+	require.NoError(t, err)
+	require.FileExists(t, filepath.Join(outputDir, "10MB-1.html"))
+	require.FileExists(t, filepath.Join(outputDir, "report.md"))
+	b, err := os.ReadFile(filepath.Join(outputDir, "report.html"))
+	require.NoError(t, err)
+	require.Contains(t, string(b), "spegel-v0.3")
+	require.Contains(t, string(b), "Nominal pull throughput in MB/s")
+	b, err = os.ReadFile(filepath.Join(outputDir, "summary.json"))
+	require.NoError(t, err)
+	summaries := []BenchmarkSummary{}
+	err = json.Unmarshal(b, &summaries)
+	require.NoError(t, err)
+	require.Len(t, summaries, 3)
+	require.InDelta(t, 3.0, summaries[2].Create.Median, 0)
+	require.NotNil(t, summaries[2].CreateThroughput)
+	require.InDelta(t, 10.0/3, summaries[2].CreateThroughput.Median, 1e-9)
+	require.Equal(t, ThroughputNominal, summaries[2].ThroughputSource)
+	require.FileExists(t, filepath.Join(outputDir, "10MB-1-throughput.html"))
+	b, err = os.ReadFile(filepath.Join(outputDir, "significance.json"))
+	require.NoError(t, err)
+	significance := []Significance{}
+	err = json.Unmarshal(b, &significance)
+	require.NoError(t, err)
+	require.Len(t, significance, 4)
+	require.Equal(t, "baseline", significance[0].Baseline)
+	require.InDelta(t, 1.0, significance[0].EffectSize, 0)
+
+	err = Analyze(t.Context(), []string{suitePaths[0], suitePaths[1], suitePaths[0]}, outputDir, KeysUnion, ImageSizeFromKey)
+	require.EqualError(t, err, "suites "+suitePaths[0]+" and "+suitePaths[0]+" have the same name baseline")
+}
+
+func TestBenchmarkKeys(t *testing.T) {
+	t.Parallel()
+
+	suites := []measure.Suite{
+		{
+			Name: "a",
+			Benchmarks: map[string]measure.Benchmark{
+				"1GB-1":             {},
+				"10MB-4":            {},
+				"10MB-1":            {},
+				"deployment-10MB-1": {},
+			},
+		},
+		{
+			Name: "b",
+			Benchmarks: map[string]measure.Benchmark{
+				"100MB-1": {},
+				"10MB-1":  {},
+				"1GB-1":   {},
+			},
+		},
+	}
+	keys, missing, err := benchmarkKeys(suites, KeysUnion)
+	require.NoError(t, err)
+	require.Equal(t, []string{"10MB-1", "10MB-4", "100MB-1", "1GB-1", "deployment-10MB-1"}, keys)
+	require.Equal(t, map[string][]string{"a": {"100MB-1"}, "b": {"10MB-4", "deployment-10MB-1"}}, missing)
+
+	keys, _, err = benchmarkKeys(suites, KeysIntersection)
+	require.NoError(t, err)
+	require.Equal(t, []string{"10MB-1", "1GB-1"}, keys)
+
+	_, _, err = benchmarkKeys(suites, "foo")
+	require.EqualError(t, err, "unknown benchmark key mode foo")
+}
+
+func TestSortBenchmarkKeys(t *testing.T) {
+	t.Parallel()
+
+	keys := []string{"custom", "new-node-1GB-4", "1GB-1", "new-node-10MB-4", "100MB-4", "100MB-1"}
+	sortBenchmarkKeys(keys)
+	require.Equal(t, []string{"100MB-1", "100MB-4", "1GB-1", "new-node-10MB-4", "new-node-1GB-4", "custom"}, keys)
+}
+
+func TestMannWhitneyU(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name               string
+		x                  []float64
+		y                  []float64
+		expectedU          float64
+		expectedPValue     float64
+		expectedEffectSize float64
+	}{
+		{
+			name:               "faster",
+			x:                  []float64{1, 2, 3},
+			y:                  []float64{4, 5, 6},
+			expectedU:          0,
+			expectedPValue:     0.1,
+			expectedEffectSize: -1,
+		},
+		{
+			name:               "interleaved",
+			x:                  []float64{1, 3, 5},
+			y:                  []float64{2, 4, 6},
+			expectedU:          3,
+			expectedPValue:     0.7,
+			expectedEffectSize: -1.0 / 3,
+		},
+		{
+			name:               "slower",
+			x:                  []float64{1.5, 2.5, 7, 8, 9, 10, 11},
+			y:                  []float64{1, 2, 3, 4, 5, 6},
+			expectedU:          33,
+			expectedPValue:     0.101399,
+			expectedEffectSize: 4.0 / 7,
+		},
+		{
+			name:               "slower with ties",
+			x:                  []float64{3, 4, 4, 5, 6},
+			y:                  []float64{1, 2, 3, 4},
+			expectedU:          17.5,
+			expectedPValue:     0.079856,
+			expectedEffectSize: 0.75,
+		},
+		{
+			name:               "equal",
+			x:                  []float64{2, 2},
+			y:                  []float64{2, 2},
+			expectedU:          2,
+			expectedPValue:     1,
+			expectedEffectSize: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			u, pValue, effectSize := mannWhitneyU(tt.x, tt.y)
+			require.InDelta(t, tt.expectedU, u, 1e-9)
+			require.InDelta(t, tt.expectedPValue, pValue, 1e-6)
+			require.InDelta(t, tt.expectedEffectSize, effectSize, 1e-9)
+		})
+	}
+}
+
+func TestParseThreshold(t *testing.T) {
+	t.Parallel()
+
+	threshold, err := ParseThreshold("update.p95=10%")
+	require.NoError(t, err)
+	require.Equal(t, Threshold{Phase: "update", Statistic: "p95", MaxIncrease: 10}, threshold)
+	require.Equal(t, "update.p95<=+10%", threshold.String())
+
+	_, err = ParseThreshold("update.p95")
+	require.EqualError(t, err, "threshold update.p95 is not in the format phase.statistic=percent")
+	_, err = ParseThreshold("delete.p95=10")
+	require.EqualError(t, err, "unknown phase delete in threshold delete.p95=10")
+	_, err = ParseThreshold("create.p42=10")
+	require.EqualError(t, err, "unknown statistic p42 in threshold create.p42=10")
+}
+
+func TestCompare(t *testing.T) {
+	t.Parallel()
+
+	samples := func(durations ...int) []measure.Sample {
+		s := []measure.Sample{}
+		for _, d := range durations {
+			s = append(s, measure.Sample{Duration: time.Duration(d) * time.Second})
+		}
+		return s
+	}
+	baseline := measure.Suite{
+		Name: "baseline",
+		Benchmarks: map[string]measure.Benchmark{
+			"10MB-1":  {Create: measure.Measurement{Samples: samples(10, 10)}, Update: measure.Measurement{Samples: samples(10, 10)}},
+			"100MB-1": {Create: measure.Measurement{Samples: samples(10, 10)}, Update: measure.Measurement{Samples: samples(10, 10)}},
+			"1GB-1":   {Create: measure.Measurement{Samples: samples(10, 10)}, Update: measure.Measurement{Samples: samples(10, 10)}},
+		},
+	}
+	candidate := measure.Suite{
+		Name: "candidate",
+		Benchmarks: map[string]measure.Benchmark{
+			"10MB-1":  {Create: measure.Measurement{Samples: samples(5, 5)}, Update: measure.Measurement{Samples: samples(11, 11)}},
+			"100MB-1": {Create: measure.Measurement{Samples: samples(10, 10)}, Update: measure.Measurement{Samples: samples(12, 12)}},
+		},
+	}
+	dir := t.TempDir()
+	paths := []string{}
+	for _, suite := range []measure.Suite{baseline, candidate} {
+		b, err := json.Marshal(suite)
+		require.NoError(t, err)
+		path := filepath.Join(dir, suite.Name+".json")
+		err = os.WriteFile(path, b, 0o644)
+		require.NoError(t, err)
+		paths = append(paths, path)
+	}
+
+	thresholds := []Threshold{{Phase: "update", Statistic: "p95", MaxIncrease: 10}}
+	buf := &bytes.Buffer{}
+	err := Compare(paths[0], paths[1], thresholds, buf)
+	require.EqualError(t, err, "2 of 3 checks failed")
+	expected := `BENCHMARK  THRESHOLD         BASELINE  CANDIDATE  CHANGE  VERDICT
+10MB-1     update.p95<=+10%  10.000s   11.000s    +10.0%  pass
+100MB-1    update.p95<=+10%  10.000s   12.000s    +20.0%  regressed
+1GB-1      update.p95<=+10%  -         -          -       missing from candidate
+`
+	require.Equal(t, expected, buf.String())
+}
+
+func TestWriteReport(t *testing.T) {
+	t.Parallel()
+
+	rep := report{
+		Suites: []reportSuite{
+			{
+				Name: "baseline",
+				Environment: environment{
+					KubernetesVersion: "v1.31.0",
+					Spegel:            spegelNone,
+					Zone:              "eu-west-1a|eu-west-1b",
+					NodeCount:         2,
+				},
+			},
+		},
+		Benchmarks: []reportBenchmark{
+			{
+				Name:   "10MB-1",
+				Charts: []reportChart{{Element: `<div id="chart"></div>`}},
+				Summaries: []BenchmarkSummary{
+					{Suite: "baseline", Benchmark: "10MB-1", Create: summarize([]float64{1, 2}), Update: summarize(nil), CreateThroughput: &Summary{Count: 1, Min: 10, P25: 10, Median: 10, P75: 10, P90: 10, P95: 10, P99: 10, Max: 10, Mean: 10}},
+				},
+			},
+		},
+	}
+	dir := t.TempDir()
+	err := writeReport(dir, rep)
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(filepath.Join(dir, "report.md"))
+	require.NoError(t, err)
+	expected := "# Benchmark Report\n" +
+		"\n" +
+		"## Environment\n" +
+		"\n" +
+		"| Suite | Kubernetes | Nodes | CNI | Spegel | Container runtime | Kernel | OS image | Platform | Instance type | Zone |\n" +
+		"| --- | --- | --- | --- | --- | --- | --- | --- | --- | --- | --- |\n" +
+		"| baseline | v1.31.0 | 2 | - | none | - | - | - | - | - | eu-west-1a\\|eu-west-1b |\n" +
+		"\n" +
+		"## 10MB-1\n" +
+		"\n" +
+		"Pull durations in seconds.\n" +
+		"\n" +
+		"| Suite | Phase | Count | Min | P25 | Median | P75 | P90 | P95 | P99 | Max | Mean | Std dev |\n" +
+		"| --- | --- | --- | --- | --- | --- | --- | --- | --- | --- | --- | --- | --- |\n" +
+		"| baseline | create | 2 | 1.000 | 1.250 | 1.500 | 1.750 | 1.900 | 1.950 | 1.990 | 2.000 | 1.500 | 0.707 |\n" +
+		"| baseline | update | 0 | 0.000 | 0.000 | 0.000 | 0.000 | 0.000 | 0.000 | 0.000 | 0.000 | 0.000 | 0.000 |\n" +
+		"\n" +
+		"Pull throughput in MB/s.\n" +
+		"\n" +
+		"| Suite | Phase | Count | Min | P25 | Median | P75 | P90 | P95 | P99 | Max | Mean | Std dev |\n" +
+		"| --- | --- | --- | --- | --- | --- | --- | --- | --- | --- | --- | --- | --- |\n" +
+		"| baseline | create | 1 | 10.000 | 10.000 | 10.000 | 10.000 | 10.000 | 10.000 | 10.000 | 10.000 | 10.000 | 0.000 |\n" +
+		"\n" +
+		"```mermaid\n" +
+		"xychart-beta\n" +
+		"    title \"10MB-1 median duration\"\n" +
+		"    x-axis [\"baseline create\"]\n" +
+		"    y-axis \"Duration (seconds)\"\n" +
+		"    bar [1.500]\n" +
+		"```\n"
+	require.Equal(t, expected, string(b))
+
+	b, err = os.ReadFile(filepath.Join(dir, "report.html"))
+	require.NoError(t, err)
+	require.Contains(t, string(b), "<script>"+echartsScript+"</script>")
+	require.NotContains(t, string(b), "<script src=")
+	require.Contains(t, string(b), `<h2>10MB-1</h2>`)
+	require.Contains(t, string(b), `<div id="chart"></div>`)
+	require.Contains(t, string(b), `<td>eu-west-1a|eu-west-1b</td>`)
+	require.Contains(t, string(b), `<tr><td>baseline</td><td>create</td><td>1</td><td>10.000</td>`)
+}
+
+func TestExport(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	suite := measure.Suite{
+		Name: "baseline",
+		Benchmarks: map[string]measure.Benchmark{
+			"1GB-1": {
+				Create: measure.Measurement{Samples: []measure.Sample{{Node: "a", Start: start, Stop: start.Add(1500 * time.Millisecond), Duration: 1500 * time.Millisecond}}},
+			},
+			"10MB-1": {
+				Create: measure.Measurement{Samples: []measure.Sample{{Node: "a", Start: start, Stop: start.Add(time.Second), Duration: time.Second}}},
+				Update: measure.Measurement{Samples: []measure.Sample{{Node: "b", Start: start, Stop: start.Add(2 * time.Second), Duration: 2 * time.Second}}},
+			},
+		},
+	}
+	b, err := json.Marshal(suite)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	err = os.WriteFile(path, b, 0o644)
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	err = Export([]string{path}, ExportCSV, buf)
+	require.NoError(t, err)
+	expected := `suite,benchmark,phase,node,start,stop,duration
+baseline,10MB-1,create,a,2024-01-02T03:04:05Z,2024-01-02T03:04:06Z,1
+baseline,10MB-1,update,b,2024-01-02T03:04:05Z,2024-01-02T03:04:07Z,2
+baseline,1GB-1,create,a,2024-01-02T03:04:05Z,2024-01-02T03:04:06.5Z,1.5
+`
+	require.Equal(t, expected, buf.String())
+
+	buf = &bytes.Buffer{}
+	err = Export([]string{path}, ExportParquet, buf)
+	require.NoError(t, err)
+	rows, err := parquet.Read[SampleRow](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	require.Equal(t, "update", rows[1].Phase)
+	require.Equal(t, "b", rows[1].Node)
+	require.True(t, start.Equal(rows[1].Start))
+	require.InDelta(t, 2.0, rows[1].Duration, 0)
+
+	err = Export([]string{path}, "xlsx", buf)
+	require.EqualError(t, err, "unknown export format xlsx")
+}
+
+func TestNewTimeline(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	suites := []measure.Suite{
+		{
+			Name: "a",
+			Benchmarks: map[string]measure.Benchmark{
+				"10MB-1": {
+					Update: measure.Measurement{
+						Samples: []measure.Sample{
+							{Node: "node-2", Start: start.Add(time.Second), Duration: 2 * time.Second},
+							{Node: "node-1", Start: start, Duration: time.Second},
+						},
+					},
+				},
+			},
+		},
+		{
+			Name: "b",
+			Benchmarks: map[string]measure.Benchmark{
+				"10MB-1": {
+					Update: measure.Measurement{Samples: []measure.Sample{{Node: "node-1", Duration: time.Second}}},
+				},
+			},
+		},
+	}
+	_, ok := newTimeline(suites, []string{"a", "b"}, "10MB-1", "create")
+	require.False(t, ok)
+
+	bar, ok := newTimeline(suites, []string{"a", "b"}, "10MB-1", "update")
+	require.True(t, ok)
+	require.Len(t, bar.MultiSeries, 2)
+	require.Equal(t, "a offset", bar.MultiSeries[0].Name)
+	require.Equal(t, []opts.BarData{{Value: 0.0}, {Value: 1.0}}, bar.MultiSeries[0].Data)
+	require.Equal(t, []opts.BarData{{Value: 1.0}, {Value: 2.0}}, bar.MultiSeries[1].Data)
+	snippet := bar.RenderSnippet()
+	require.Contains(t, snippet.Option, `["a node-1 #1","a node-2 #2"]`)
+}
+
+func TestCDFData(t *testing.T) {
+	t.Parallel()
+
+	data := cdfData([]float64{1, 2, 2, 4})
+	expected := []opts.LineData{
+		{Value: []float64{1, 0}},
+		{Value: []float64{1, 0.25}},
+		{Value: []float64{2, 0.5}},
+		{Value: []float64{2, 0.75}},
+		{Value: []float64{4, 1}},
+	}
+	require.Equal(t, expected, data)
+}
+
+func TestHistogram(t *testing.T) {
+	t.Parallel()
+
+	dividers, counts := histogram([][]float64{{1, 2, 3, 4, 5}, {5}})
+	require.Len(t, dividers, 5)
+	require.InDeltaSlice(t, []float64{1, 2, 3, 4, 5}, dividers, 1e-9)
+	require.Greater(t, dividers[4], 5.0)
+	require.Equal(t, [][]float64{{1, 1, 1, 2}, {0, 0, 0, 1}}, counts)
+
+	dividers, counts = histogram([][]float64{{2, 2}})
+	require.Len(t, dividers, 2)
+	require.Equal(t, [][]float64{{2}}, counts)
+}
+
+func TestNewHistogram(t *testing.T) {
+	t.Parallel()
+
+	suites := []measure.Suite{
+		{Name: "a", Benchmarks: map[string]measure.Benchmark{"10MB-1": {Create: measure.Measurement{Samples: []measure.Sample{{Duration: time.Second}, {Duration: 2 * time.Second}}}}}},
+		{Name: "b", Benchmarks: map[string]measure.Benchmark{}},
+	}
+	_, ok := newHistogram(suites, []string{"a", "b"}, "10MB-1", "update")
+	require.False(t, ok)
+	_, ok = newCDF(suites, []string{"a", "b"}, "10MB-1", "update")
+	require.False(t, ok)
+
+	bar, ok := newHistogram(suites, []string{"a", "b"}, "10MB-1", "create")
+	require.True(t, ok)
+	require.Len(t, bar.MultiSeries, 1)
+	require.Equal(t, []opts.BarData{{Value: 0.5}, {Value: 0.5}}, bar.MultiSeries[0].Data)
+	line, ok := newCDF(suites, []string{"a", "b"}, "10MB-1", "create")
+	require.True(t, ok)
+	require.Len(t, line.MultiSeries, 1)
+}
+
+func TestThroughputs(t *testing.T) {
+	t.Parallel()
+
+	samples := []measure.Sample{{Duration: 2 * time.Second}, {Duration: 0}, {Duration: 500 * time.Millisecond}}
+	require.Equal(t, []float64{5, 20}, throughputs(samples, 10*1024*1024))
+}
+
+func TestImageSizes(t *testing.T) {
+	t.Parallel()
+
+	_, err := newImageSizes("kubelet")
+	require.EqualError(t, err, "unknown image size source kubelet")
+
+	sizes, err := newImageSizes(ImageSizeFromKey)
+	require.NoError(t, err)
+	size, ok, err := sizes.size(t.Context(), "deployment-10MB-4", "")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, int64(10*1024*1024), size)
+	_, ok, err = sizes.size(t.Context(), "custom", "")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	srv := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(srv.Close)
+	img, err := random.Image(1024, 2)
+	require.NoError(t, err)
+	image := strings.TrimPrefix(srv.URL, "http://") + "/benchmark:v1-10MB-1"
+	ref, err := name.ParseReference(image)
+	require.NoError(t, err)
+	err = remote.Write(ref, img)
+	require.NoError(t, err)
+	manifest, err := img.Manifest()
+	require.NoError(t, err)
+	expected := manifest.Config.Size + manifest.Layers[0].Size + manifest.Layers[1].Size
+
+	sizes, err = newImageSizes(ImageSizeFromRegistry)
+	require.NoError(t, err)
+	size, ok, err = sizes.size(t.Context(), "10MB-1", image)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, expected, size)
+	require.Equal(t, map[string]int64{image: expected}, sizes.cache)
+	_, ok, err = sizes.size(t.Context(), "10MB-1", "")
+	require.NoError(t, err)
+	require.False(t, ok)
 }
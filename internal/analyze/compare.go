@@ -0,0 +1,196 @@
+package analyze
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spegel-org/benchmark/internal/measure"
+)
+
+// Threshold is the maximum relative increase of a statistic in a phase before a benchmark is considered regressed.
+type Threshold struct {
+	Phase     string
+	Statistic string
+	// MaxIncrease is the allowed increase in percent.
+	MaxIncrease float64
+}
+
+func (t Threshold) String() string {
+	return fmt.Sprintf("%s.%s<=+%s%%", t.Phase, t.Statistic, strconv.FormatFloat(t.MaxIncrease, 'f', -1, 64))
+}
+
+// ParseThreshold parses thresholds in the format phase.statistic=percent, for example update.p95=10.
+func ParseThreshold(s string) (Threshold, error) {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok {
+		return Threshold{}, fmt.Errorf("threshold %s is not in the format phase.statistic=percent", s)
+	}
+	phase, statistic, ok := strings.Cut(key, ".")
+	if !ok {
+		return Threshold{}, fmt.Errorf("threshold %s is not in the format phase.statistic=percent", s)
+	}
+	if phase != "create" && phase != "update" {
+		return Threshold{}, fmt.Errorf("unknown phase %s in threshold %s", phase, s)
+	}
+	_, ok = summaryStatistic(Summary{}, statistic)
+	if !ok {
+		return Threshold{}, fmt.Errorf("unknown statistic %s in threshold %s", statistic, s)
+	}
+	maxIncrease, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil {
+		return Threshold{}, fmt.Errorf("invalid percentage in threshold %s: %w", s, err)
+	}
+	return Threshold{
+		Phase:       phase,
+		Statistic:   statistic,
+		MaxIncrease: maxIncrease,
+	}, nil
+}
+
+func summaryStatistic(s Summary, name string) (float64, bool) {
+	switch name {
+	case "min":
+		return s.Min, true
+	case "p25":
+		return s.P25, true
+	case "median":
+		return s.Median, true
+	case "p75":
+		return s.P75, true
+	case "p90":
+		return s.P90, true
+	case "p95":
+		return s.P95, true
+	case "p99":
+		return s.P99, true
+	case "max":
+		return s.Max, true
+	case "mean":
+		return s.Mean, true
+	default:
+		return 0, false
+	}
+}
+
+// Verdict is the result of applying a threshold to a benchmark.
+type Verdict struct {
+	Benchmark string
+	Threshold Threshold
+	Message   string
+	Baseline  float64
+	Candidate float64
+	// Change is the relative change from the baseline in percent, or NaN if the benchmark could not be compared.
+	Change float64
+	Passed bool
+}
+
+// Compare applies the thresholds to every benchmark of the baseline suite and writes a verdict table.
+func Compare(baselinePath, candidatePath string, thresholds []Threshold, w io.Writer) error {
+	baseline, err := loadSuite(baselinePath)
+	if err != nil {
+		return err
+	}
+	candidate, err := loadSuite(candidatePath)
+	if err != nil {
+		return err
+	}
+	verdicts := compareThresholds(baseline, candidate, thresholds)
+	err = writeVerdicts(w, baseline.Name, candidate.Name, verdicts)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, v := range verdicts {
+		if !v.Passed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(verdicts))
+	}
+	return nil
+}
+
+func compareThresholds(baseline, candidate measure.Suite, thresholds []Threshold) []Verdict {
+	keys := []string{}
+	for k := range baseline.Benchmarks {
+		keys = append(keys, k)
+	}
+	sortBenchmarkKeys(keys)
+
+	verdicts := []Verdict{}
+	for _, k := range keys {
+		baselineSummary := summarizeBenchmark(baseline.Name, k, baseline.Benchmarks[k])
+		candidateBenchmark, ok := candidate.Benchmarks[k]
+		if !ok {
+			for _, t := range thresholds {
+				verdicts = append(verdicts, Verdict{Benchmark: k, Threshold: t, Change: math.NaN(), Message: "missing from candidate"})
+			}
+			continue
+		}
+		candidateSummary := summarizeBenchmark(candidate.Name, k, candidateBenchmark)
+		for _, t := range thresholds {
+			verdicts = append(verdicts, applyThreshold(k, t, baselineSummary, candidateSummary))
+		}
+	}
+	return verdicts
+}
+
+func applyThreshold(benchmarkName string, t Threshold, baseline, candidate BenchmarkSummary) Verdict {
+	baselinePhase, candidatePhase := baseline.Create, candidate.Create
+	if t.Phase == "update" {
+		baselinePhase, candidatePhase = baseline.Update, candidate.Update
+	}
+	v := Verdict{
+		Benchmark: benchmarkName,
+		Threshold: t,
+	}
+	if baselinePhase.Count == 0 || candidatePhase.Count == 0 {
+		v.Change = math.NaN()
+		v.Message = "no samples"
+		return v
+	}
+	v.Baseline, _ = summaryStatistic(baselinePhase, t.Statistic)
+	v.Candidate, _ = summaryStatistic(candidatePhase, t.Statistic)
+	switch {
+	case v.Baseline == v.Candidate:
+		v.Change = 0
+	case v.Baseline == 0:
+		v.Change = math.Inf(1)
+	default:
+		v.Change = (v.Candidate - v.Baseline) / v.Baseline * 100
+	}
+	v.Passed = v.Change <= t.MaxIncrease
+	if v.Passed {
+		v.Message = "pass"
+	} else {
+		v.Message = "regressed"
+	}
+	return v
+}
+
+func writeVerdicts(w io.Writer, baselineName, candidateName string, verdicts []Verdict) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, err := fmt.Fprintf(tw, "BENCHMARK\tTHRESHOLD\t%s\t%s\tCHANGE\tVERDICT\n", strings.ToUpper(baselineName), strings.ToUpper(candidateName))
+	if err != nil {
+		return err
+	}
+	for _, v := range verdicts {
+		if math.IsNaN(v.Change) {
+			_, err := fmt.Fprintf(tw, "%s\t%s\t-\t-\t-\t%s\n", v.Benchmark, v.Threshold, v.Message)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		_, err := fmt.Fprintf(tw, "%s\t%s\t%.3fs\t%.3fs\t%+.1f%%\t%s\n", v.Benchmark, v.Threshold, v.Baseline, v.Candidate, v.Change, v.Message)
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
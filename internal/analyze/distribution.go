@@ -0,0 +1,118 @@
+package analyze
+
+import (
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/stat"
+
+	"github.com/spegel-org/benchmark/internal/measure"
+)
+
+// suiteDurations returns the sorted durations of each suite with samples.
+func suiteDurations(suites []measure.Suite, benchmarkName, phase string) ([]string, [][]float64) {
+	names := []string{}
+	data := [][]float64{}
+	for _, suite := range suites {
+		d := durations(phaseSamples(suite.Benchmarks[benchmarkName], phase))
+		if len(d) == 0 {
+			continue
+		}
+		slices.Sort(d)
+		names = append(names, suite.Name)
+		data = append(data, d)
+	}
+	return names, data
+}
+
+// newCDF overlays the empirical CDF of the pull durations of each suite.
+func newCDF(suites []measure.Suite, suiteNames []string, benchmarkName, phase string) (*charts.Line, bool) {
+	names, data := suiteDurations(suites, benchmarkName, phase)
+	if len(data) == 0 {
+		return nil, false
+	}
+
+	line := charts.NewLine()
+	line.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{Title: fmt.Sprintf("%s %s CDF", benchmarkName, phase)}),
+		charts.WithGridOpts(opts.Grid{Bottom: "60"}),
+		charts.WithXAxisOpts(opts.XAxis{Type: "value", Name: "Duration (seconds)", NameLocation: "middle", NameGap: 30}),
+		charts.WithYAxisOpts(opts.YAxis{Type: "value", Name: "Share of pulls", NameLocation: "middle", NameGap: 40, Min: 0, Max: 1}),
+		charts.WithLegendOpts(opts.Legend{Type: "scroll", Left: "center", Bottom: "0"}),
+		charts.WithAnimation(false),
+	)
+	for i, d := range data {
+		line.AddSeries(names[i], cdfData(d),
+			charts.WithLineChartOpts(opts.LineChart{Step: "end", ShowSymbol: opts.Bool(false)}),
+			charts.WithItemStyleOpts(itemStyle(slices.Index(suiteNames, names[i]))),
+		)
+	}
+	return line, true
+}
+
+func cdfData(sorted []float64) []opts.LineData {
+	data := []opts.LineData{{Value: []float64{sorted[0], 0}}}
+	for i, v := range sorted {
+		data = append(data, opts.LineData{Value: []float64{v, float64(i+1) / float64(len(sorted))}})
+	}
+	return data
+}
+
+// newHistogram shows the share of pulls per bin so that suites with different node counts are comparable.
+func newHistogram(suites []measure.Suite, suiteNames []string, benchmarkName, phase string) (*charts.Bar, bool) {
+	names, data := suiteDurations(suites, benchmarkName, phase)
+	if len(data) == 0 {
+		return nil, false
+	}
+	dividers, counts := histogram(data)
+	labels := []string{}
+	for i := range len(dividers) - 1 {
+		labels = append(labels, fmt.Sprintf("%.2f-%.2f", dividers[i], dividers[i+1]))
+	}
+
+	bar := charts.NewBar()
+	bar.SetGlobalOptions(
+		charts.WithTitleOpts(opts.Title{Title: fmt.Sprintf("%s %s histogram", benchmarkName, phase)}),
+		charts.WithGridOpts(opts.Grid{Bottom: "80"}),
+		charts.WithXAxisOpts(opts.XAxis{Name: "Duration (seconds)", NameLocation: "middle", NameGap: 30}),
+		charts.WithYAxisOpts(opts.YAxis{Name: "Share of pulls", NameLocation: "middle", NameGap: 40}),
+		charts.WithLegendOpts(opts.Legend{Type: "scroll", Left: "center", Bottom: "0"}),
+		charts.WithAnimation(false),
+	)
+	bar.SetXAxis(labels)
+	for i, count := range counts {
+		values := []opts.BarData{}
+		for _, c := range count {
+			values = append(values, opts.BarData{Value: c / float64(len(data[i]))})
+		}
+		bar.AddSeries(names[i], values, charts.WithItemStyleOpts(itemStyle(slices.Index(suiteNames, names[i]))))
+	}
+	return bar, true
+}
+
+// histogram counts the data of each suite in shared bins, using Sturges' rule for the amount of bins.
+func histogram(data [][]float64) ([]float64, [][]float64) {
+	lo := math.Inf(1)
+	hi := math.Inf(-1)
+	n := 0
+	for _, d := range data {
+		lo = min(lo, d[0])
+		hi = max(hi, d[len(d)-1])
+		n = max(n, len(d))
+	}
+	bins := int(math.Ceil(math.Log2(float64(n)))) + 1
+	if lo == hi {
+		bins = 1
+	}
+	dividers := floats.Span(make([]float64, bins+1), lo, hi)
+	dividers[bins] = math.Nextafter(hi, math.Inf(1))
+	counts := [][]float64{}
+	for _, d := range data {
+		counts = append(counts, stat.Histogram(nil, dividers, d, nil))
+	}
+	return dividers, counts
+}
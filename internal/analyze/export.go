@@ -0,0 +1,120 @@
+package analyze
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/spegel-org/benchmark/internal/measure"
+)
+
+const (
+	ExportCSV     = "csv"
+	ExportParquet = "parquet"
+)
+
+// SampleRow is a single pull sample, the duration is in seconds.
+type SampleRow struct {
+	Start     time.Time `parquet:"start,timestamp(nanosecond)"`
+	Stop      time.Time `parquet:"stop,timestamp(nanosecond)"`
+	Suite     string    `parquet:"suite,dict"`
+	Benchmark string    `parquet:"benchmark,dict"`
+	Phase     string    `parquet:"phase,dict"`
+	Node      string    `parquet:"node,dict"`
+	Duration  float64   `parquet:"duration"`
+}
+
+var csvHeader = []string{"suite", "benchmark", "phase", "node", "start", "stop", "duration"}
+
+// Export writes one row per sample of the suites as CSV or Parquet.
+func Export(suitePaths []string, format string, w io.Writer) error {
+	if format != ExportCSV && format != ExportParquet {
+		return fmt.Errorf("unknown export format %s", format)
+	}
+	suites := []measure.Suite{}
+	for _, path := range suitePaths {
+		suite, err := loadSuite(path)
+		if err != nil {
+			return err
+		}
+		suites = append(suites, suite)
+	}
+	if len(suites) == 0 {
+		return errors.New("suites is empty")
+	}
+	rows := sampleRows(suites)
+	if format == ExportParquet {
+		return writeParquet(w, rows)
+	}
+	return writeCSV(w, rows)
+}
+
+func sampleRows(suites []measure.Suite) []SampleRow {
+	rows := []SampleRow{}
+	for _, suite := range suites {
+		keys := []string{}
+		for k := range suite.Benchmarks {
+			keys = append(keys, k)
+		}
+		sortBenchmarkKeys(keys)
+		for _, k := range keys {
+			benchmark := suite.Benchmarks[k]
+			for _, phase := range []struct {
+				name    string
+				samples []measure.Sample
+			}{{"create", benchmark.Create.Samples}, {"update", benchmark.Update.Samples}} {
+				for _, sample := range phase.samples {
+					rows = append(rows, SampleRow{
+						Suite:     suite.Name,
+						Benchmark: k,
+						Phase:     phase.name,
+						Node:      sample.Node,
+						Start:     sample.Start,
+						Stop:      sample.Stop,
+						Duration:  sample.Duration.Seconds(),
+					})
+				}
+			}
+		}
+	}
+	return rows
+}
+
+func writeCSV(w io.Writer, rows []SampleRow) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write(csvHeader)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		record := []string{
+			row.Suite,
+			row.Benchmark,
+			row.Phase,
+			row.Node,
+			row.Start.Format(time.RFC3339Nano),
+			row.Stop.Format(time.RFC3339Nano),
+			strconv.FormatFloat(row.Duration, 'f', -1, 64),
+		}
+		err := cw.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeParquet(w io.Writer, rows []SampleRow) error {
+	pw := parquet.NewGenericWriter[SampleRow](w)
+	_, err := pw.Write(rows)
+	if err != nil {
+		return errors.Join(err, pw.Close())
+	}
+	return pw.Close()
+}
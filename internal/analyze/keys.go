@@ -0,0 +1,115 @@
+package analyze
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/c2h5oh/datasize"
+
+	"github.com/spegel-org/benchmark/internal/measure"
+)
+
+const (
+	KeysUnion        = "union"
+	KeysIntersection = "intersection"
+)
+
+// benchmarkKeys returns the union or intersection of the benchmark keys, and the keys missing from each suite.
+func benchmarkKeys(suites []measure.Suite, mode string) ([]string, map[string][]string, error) {
+	if mode != KeysUnion && mode != KeysIntersection {
+		return nil, nil, fmt.Errorf("unknown benchmark key mode %s", mode)
+	}
+	counts := map[string]int{}
+	for _, suite := range suites {
+		for k := range suite.Benchmarks {
+			counts[k]++
+		}
+	}
+	keys := []string{}
+	for k, count := range counts {
+		if mode == KeysIntersection && count < len(suites) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sortBenchmarkKeys(keys)
+
+	missing := map[string][]string{}
+	for _, suite := range suites {
+		for k := range counts {
+			if _, ok := suite.Benchmarks[k]; ok {
+				continue
+			}
+			missing[suite.Name] = append(missing[suite.Name], k)
+		}
+		sortBenchmarkKeys(missing[suite.Name])
+	}
+	return keys, missing, nil
+}
+
+type parsedKey struct {
+	key        string
+	scenario   string
+	size       uint64
+	layerCount int
+	ok         bool
+}
+
+// parseBenchmarkKey parses keys in the format [scenario-]size-layers.
+func parseBenchmarkKey(key string) parsedKey {
+	pk := parsedKey{key: key}
+	rest, layers, ok := cutLast(key, "-")
+	if !ok {
+		return pk
+	}
+	layerCount, err := strconv.Atoi(layers)
+	if err != nil {
+		return pk
+	}
+	scenario, size, ok := cutLast(rest, "-")
+	if !ok {
+		scenario = ""
+		size = rest
+	}
+	var imageSize datasize.ByteSize
+	err = imageSize.UnmarshalText([]byte(size))
+	if err != nil {
+		return pk
+	}
+	pk.scenario = scenario
+	pk.size = imageSize.Bytes()
+	pk.layerCount = layerCount
+	pk.ok = true
+	return pk
+}
+
+func cutLast(s, sep string) (string, string, bool) {
+	i := strings.LastIndex(s, sep)
+	if i == -1 {
+		return "", s, false
+	}
+	return s[:i], s[i+len(sep):], true
+}
+
+// sortBenchmarkKeys orders keys by scenario, image size and layer count.
+func sortBenchmarkKeys(keys []string) {
+	slices.SortFunc(keys, func(a, b string) int {
+		pa := parseBenchmarkKey(a)
+		pb := parseBenchmarkKey(b)
+		if pa.ok != pb.ok {
+			if pa.ok {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Or(
+			cmp.Compare(pa.scenario, pb.scenario),
+			cmp.Compare(pa.size, pb.size),
+			cmp.Compare(pa.layerCount, pb.layerCount),
+			cmp.Compare(a, b),
+		)
+	})
+}
@@ -0,0 +1,51 @@
+package analyze
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/go-echarts/go-echarts/v2/opts"
+)
+
+// goldenAngle spreads generated hues so that neighbouring suites get clearly different colors.
+const goldenAngle = 137.508
+
+// basePalette are the colors of the first suites.
+var basePalette = []opts.ItemStyle{
+	{BorderColor: "#164577", Color: "#9CC1E3"},
+	{BorderColor: "#FAA93B", Color: "#FAEAD4"},
+}
+
+// itemStyle returns the style of the suite with the given index.
+func itemStyle(i int) opts.ItemStyle {
+	if i < len(basePalette) {
+		return basePalette[i]
+	}
+	hue := math.Mod(float64(i-len(basePalette)+1)*goldenAngle+210, 360)
+	return opts.ItemStyle{
+		BorderColor: hslToHex(hue, 0.65, 0.35),
+		Color:       hslToHex(hue, 0.65, 0.85),
+	}
+}
+
+func hslToHex(h, s, l float64) string {
+	c := (1 - math.Abs(2*l-1)) * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := l - c/2
+	r, g, b := 0.0, 0.0, 0.0
+	switch {
+	case h < 60:
+		r, g = c, x
+	case h < 120:
+		r, g = x, c
+	case h < 180:
+		g, b = c, x
+	case h < 240:
+		g, b = x, c
+	case h < 300:
+		r, b = x, c
+	default:
+		r, b = c, x
+	}
+	return fmt.Sprintf("#%02X%02X%02X", int(math.Round((r+m)*255)), int(math.Round((g+m)*255)), int(math.Round((b+m)*255)))
+}
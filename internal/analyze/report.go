@@ -0,0 +1,259 @@
+package analyze
+
+import (
+	_ "embed"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/go-echarts/go-echarts/v2/render"
+)
+
+const spegelNone = "none"
+
+// echartsScript is ECharts 4.1.0, inlined so that the HTML report works offline. The charts only use
+// options supported by ECharts 4, the license is in assets/ECHARTS-LICENSE.
+//
+//go:embed assets/echarts-4.1.0.min.js
+var echartsScript string
+
+type report struct {
+	Suites     []reportSuite
+	Benchmarks []reportBenchmark
+}
+
+type reportSuite struct {
+	Name        string
+	Environment environment
+}
+
+type reportBenchmark struct {
+	Name      string
+	Summaries []BenchmarkSummary
+	Charts    []reportChart
+}
+
+type reportChart struct {
+	Element htmltemplate.HTML
+	Script  htmltemplate.HTML
+}
+
+func newReportChart(snippet render.ChartSnippet) reportChart {
+	return reportChart{
+		Element: htmltemplate.HTML(snippet.Element),
+		Script:  htmltemplate.HTML(snippet.Script),
+	}
+}
+
+var reportFuncs = map[string]any{
+	"number":                formatNumber,
+	"hasThroughput":         hasThroughput,
+	"throughputDescription": throughputDescription,
+	"markdownCell":          markdownCell,
+	"mermaidChart":          mermaidChart,
+	"echartsScript":         func() htmltemplate.JS { return htmltemplate.JS(echartsScript) },
+}
+
+const markdownReport = `{{- define "stats" }} {{ .Count }} | {{ number .Min }} | {{ number .P25 }} | {{ number .Median }} | {{ number .P75 }} | {{ number .P90 }} | {{ number .P95 }} | {{ number .P99 }} | {{ number .Max }} | {{ number .Mean }} | {{ number .StdDev }} |{{ end -}}
+{{- define "header" }}| Suite | Phase | Count | Min | P25 | Median | P75 | P90 | P95 | P99 | Max | Mean | Std dev |
+| --- | --- | --- | --- | --- | --- | --- | --- | --- | --- | --- | --- | --- |{{ end -}}
+# Benchmark Report
+
+## Environment
+
+| Suite | Kubernetes | Nodes | CNI | Spegel | Container runtime | Kernel | OS image | Platform | Instance type | Zone |
+| --- | --- | --- | --- | --- | --- | --- | --- | --- | --- | --- |
+{{- range .Suites }}
+{{- $env := .Environment }}
+| {{ markdownCell .Name }} | {{ markdownCell $env.KubernetesVersion }} | {{ $env.NodeCount }} | {{ markdownCell $env.CNI }} | {{ markdownCell $env.Spegel }} | {{ markdownCell $env.Runtime }} | {{ markdownCell $env.Kernel }} | {{ markdownCell $env.OSImage }} | {{ markdownCell $env.Platform }} | {{ markdownCell $env.InstanceType }} | {{ markdownCell $env.Zone }} |
+{{- end }}
+{{ range .Benchmarks }}
+## {{ .Name }}
+
+Pull durations in seconds.
+
+{{ template "header" }}
+{{- range .Summaries }}
+| {{ markdownCell .Suite }} | create |{{ template "stats" .Create }}
+| {{ markdownCell .Suite }} | update |{{ template "stats" .Update }}
+{{- end }}
+{{- if hasThroughput .Summaries }}
+
+{{ throughputDescription .Summaries }}
+
+{{ template "header" }}
+{{- range .Summaries }}
+{{- $suite := .Suite }}
+{{- with .CreateThroughput }}
+| {{ markdownCell $suite }} | create |{{ template "stats" . }}
+{{- end }}
+{{- with .UpdateThroughput }}
+| {{ markdownCell $suite }} | update |{{ template "stats" . }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{ mermaidChart . }}
+{{ end -}}
+`
+
+const htmlReport = `{{- define "stats" }}<td>{{ .Count }}</td><td>{{ number .Min }}</td><td>{{ number .P25 }}</td><td>{{ number .Median }}</td><td>{{ number .P75 }}</td><td>{{ number .P90 }}</td><td>{{ number .P95 }}</td><td>{{ number .P99 }}</td><td>{{ number .Max }}</td><td>{{ number .Mean }}</td><td>{{ number .StdDev }}</td>{{ end -}}
+{{- define "header" }}<tr><th>Suite</th><th>Phase</th><th>Count</th><th>Min</th><th>P25</th><th>Median</th><th>P75</th><th>P90</th><th>P95</th><th>P99</th><th>Max</th><th>Mean</th><th>Std dev</th></tr>{{ end -}}
+<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Benchmark Report</title>
+<script>{{ echartsScript }}</script>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+</style>
+</head>
+<body>
+<h1>Benchmark Report</h1>
+<h2>Environment</h2>
+<table>
+<tr><th>Suite</th><th>Kubernetes</th><th>Nodes</th><th>CNI</th><th>Spegel</th><th>Container runtime</th><th>Kernel</th><th>OS image</th><th>Platform</th><th>Instance type</th><th>Zone</th></tr>
+{{- range .Suites }}
+{{- $env := .Environment }}
+<tr><td>{{ .Name }}</td><td>{{ $env.KubernetesVersion }}</td><td>{{ $env.NodeCount }}</td><td>{{ $env.CNI }}</td><td>{{ $env.Spegel }}</td><td>{{ $env.Runtime }}</td><td>{{ $env.Kernel }}</td><td>{{ $env.OSImage }}</td><td>{{ $env.Platform }}</td><td>{{ $env.InstanceType }}</td><td>{{ $env.Zone }}</td></tr>
+{{- end }}
+</table>
+{{- range .Benchmarks }}
+<h2>{{ .Name }}</h2>
+<p>Pull durations in seconds.</p>
+<table>
+{{ template "header" }}
+{{- range .Summaries }}
+<tr><td>{{ .Suite }}</td><td>create</td>{{ template "stats" .Create }}</tr>
+<tr><td>{{ .Suite }}</td><td>update</td>{{ template "stats" .Update }}</tr>
+{{- end }}
+</table>
+{{- if hasThroughput .Summaries }}
+<p>{{ throughputDescription .Summaries }}</p>
+<table>
+{{ template "header" }}
+{{- range .Summaries }}
+{{- $suite := .Suite }}
+{{- with .CreateThroughput }}
+<tr><td>{{ $suite }}</td><td>create</td>{{ template "stats" . }}</tr>
+{{- end }}
+{{- with .UpdateThroughput }}
+<tr><td>{{ $suite }}</td><td>update</td>{{ template "stats" . }}</tr>
+{{- end }}
+{{- end }}
+</table>
+{{- end }}
+{{- range .Charts }}
+{{ .Element }}
+{{ .Script }}
+{{- end }}
+{{- end }}
+</body>
+</html>
+`
+
+// writeReport writes the report as Markdown and as a single HTML page.
+func writeReport(outputDir string, rep report) error {
+	mdTmpl, err := template.New("report.md").Funcs(reportFuncs).Parse(markdownReport)
+	if err != nil {
+		return err
+	}
+	htmlTmpl, err := htmltemplate.New("report.html").Funcs(reportFuncs).Parse(htmlReport)
+	if err != nil {
+		return err
+	}
+	mdFile, err := os.Create(filepath.Join(outputDir, "report.md"))
+	if err != nil {
+		return err
+	}
+	defer mdFile.Close()
+	err = mdTmpl.Execute(mdFile, rep)
+	if err != nil {
+		return err
+	}
+	htmlFile, err := os.Create(filepath.Join(outputDir, "report.html"))
+	if err != nil {
+		return err
+	}
+	defer htmlFile.Close()
+	err = htmlTmpl.Execute(htmlFile, rep)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', 3, 64)
+}
+
+func hasThroughput(summaries []BenchmarkSummary) bool {
+	for _, s := range summaries {
+		if s.CreateThroughput != nil || s.UpdateThroughput != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func isNominalThroughput(summaries []BenchmarkSummary) bool {
+	for _, s := range summaries {
+		if s.ThroughputSource == ThroughputNominal {
+			return true
+		}
+	}
+	return false
+}
+
+func throughputDescription(summaries []BenchmarkSummary) string {
+	if isNominalThroughput(summaries) {
+		return "Nominal pull throughput in MB/s, computed from the image size in the benchmark name rather than the bytes transferred."
+	}
+	return "Pull throughput in MB/s."
+}
+
+// markdownCell escapes pipes and replaces empty values with a dash.
+func markdownCell(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.ReplaceAll(s, "|", "\\|")
+}
+
+// mermaidChart charts the medians, as Markdown comments cannot embed ECharts.
+func mermaidChart(b reportBenchmark) string {
+	labels := []string{}
+	values := []string{}
+	for _, s := range b.Summaries {
+		for _, phase := range []struct {
+			name    string
+			summary Summary
+		}{{"create", s.Create}, {"update", s.Update}} {
+			if phase.summary.Count == 0 {
+				continue
+			}
+			labels = append(labels, strconv.Quote(strings.ReplaceAll(s.Suite, `"`, "'")+" "+phase.name))
+			values = append(values, formatNumber(phase.summary.Median))
+		}
+	}
+	if len(labels) == 0 {
+		return ""
+	}
+	lines := []string{
+		"```mermaid",
+		"xychart-beta",
+		"    title " + strconv.Quote(b.Name+" median duration"),
+		"    x-axis [" + strings.Join(labels, ", ") + "]",
+		`    y-axis "Duration (seconds)"`,
+		"    bar [" + strings.Join(values, ", ") + "]",
+		"```",
+	}
+	return strings.Join(lines, "\n")
+}
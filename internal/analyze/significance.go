@@ -0,0 +1,167 @@
+package analyze
+
+import (
+	"cmp"
+	"math"
+	"slices"
+
+	"gonum.org/v1/gonum/stat/distuv"
+
+	"github.com/spegel-org/benchmark/internal/measure"
+)
+
+// Significance is the result of a Mann-Whitney U test of a suite against the baseline.
+type Significance struct {
+	Benchmark string `json:"benchmark"`
+	Phase     string `json:"phase"`
+	Baseline  string `json:"baseline"`
+	Suite     string `json:"suite"`
+	// U counts the pairs where the suite is slower, ties count as a half.
+	U float64 `json:"u"`
+	// PValue is exact for small samples without ties, otherwise it uses the normal approximation.
+	PValue float64 `json:"pValue"`
+	// EffectSize is the rank-biserial correlation, positive when the suite is slower.
+	EffectSize float64 `json:"effectSize"`
+	// MedianDifference is in seconds.
+	MedianDifference float64 `json:"medianDifference"`
+}
+
+// compareSuites tests each suite against the first suite for each phase of the benchmark.
+func compareSuites(benchmarkName string, suites []measure.Suite) []Significance {
+	if len(suites) < 2 {
+		return nil
+	}
+	baseline, ok := suites[0].Benchmarks[benchmarkName]
+	if !ok {
+		return nil
+	}
+	results := []Significance{}
+	for _, suite := range suites[1:] {
+		benchmark, ok := suite.Benchmarks[benchmarkName]
+		if !ok {
+			continue
+		}
+		for _, phase := range []struct {
+			name      string
+			baseline  []measure.Sample
+			candidate []measure.Sample
+		}{
+			{name: "create", baseline: baseline.Create.Samples, candidate: benchmark.Create.Samples},
+			{name: "update", baseline: baseline.Update.Samples, candidate: benchmark.Update.Samples},
+		} {
+			x := durations(phase.candidate)
+			y := durations(phase.baseline)
+			if len(x) == 0 || len(y) == 0 {
+				continue
+			}
+			u, pValue, effectSize := mannWhitneyU(x, y)
+			results = append(results, Significance{
+				Benchmark:        benchmarkName,
+				Phase:            phase.name,
+				Baseline:         suites[0].Name,
+				Suite:            suite.Name,
+				U:                u,
+				PValue:           pValue,
+				EffectSize:       effectSize,
+				MedianDifference: summarize(x).Median - summarize(y).Median,
+			})
+		}
+	}
+	return results
+}
+
+// exactMaxSamples is the largest amount of samples for which the exact p-value is computed.
+const exactMaxSamples = 50
+
+// mannWhitneyU returns the U statistic of x, the two sided p-value and the rank-biserial correlation.
+func mannWhitneyU(x, y []float64) (float64, float64, float64) {
+	type value struct {
+		v     float64
+		fromX bool
+	}
+	values := []value{}
+	for _, v := range x {
+		values = append(values, value{v: v, fromX: true})
+	}
+	for _, v := range y {
+		values = append(values, value{v: v})
+	}
+	slices.SortFunc(values, func(a, b value) int {
+		return cmp.Compare(a.v, b.v)
+	})
+
+	// Tied values get the average of their ranks.
+	rankSumX := 0.0
+	tieCorrection := 0.0
+	for i := 0; i < len(values); {
+		j := i
+		for j < len(values) && values[j].v == values[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for _, v := range values[i:j] {
+			if v.fromX {
+				rankSumX += rank
+			}
+		}
+		t := float64(j - i)
+		tieCorrection += t*t*t - t
+		i = j
+	}
+
+	n1 := float64(len(x))
+	n2 := float64(len(y))
+	n := n1 + n2
+	u := rankSumX - n1*(n1+1)/2
+	effectSize := 2*u/(n1*n2) - 1
+	if tieCorrection == 0 && len(values) <= exactMaxSamples {
+		return u, exactPValue(len(x), len(y), u), effectSize
+	}
+	mean := n1 * n2 / 2
+	sigma := math.Sqrt(n1 * n2 / 12 * ((n + 1) - tieCorrection/(n*(n-1))))
+	if sigma == 0 {
+		return u, 1, effectSize
+	}
+	z := math.Max(math.Abs(u-mean)-0.5, 0) / sigma
+	pValue := math.Min(2*distuv.UnitNormal.Survival(z), 1)
+	return u, pValue, effectSize
+}
+
+func exactPValue(n1, n2 int, u float64) float64 {
+	counts := uCounts(n1, n2)
+	total := 0.0
+	lower := 0.0
+	upper := 0.0
+	for i, c := range counts {
+		total += c
+		if float64(i) <= u {
+			lower += c
+		}
+		if float64(i) >= u {
+			upper += c
+		}
+	}
+	return math.Min(2*math.Min(lower, upper)/total, 1)
+}
+
+// uCounts returns the amount of orderings of the samples giving each U.
+func uCounts(n1, n2 int) []float64 {
+	prev := make([][]float64, n2+1)
+	for j := range prev {
+		prev[j] = []float64{1}
+	}
+	for i := 1; i <= n1; i++ {
+		cur := make([][]float64, n2+1)
+		cur[0] = []float64{1}
+		for j := 1; j <= n2; j++ {
+			counts := make([]float64, i*j+1)
+			copy(counts, cur[j-1])
+			for k, c := range prev[j] {
+				counts[k+j] += c
+			}
+			cur[j] = counts
+		}
+		prev = cur
+	}
+	return prev[n2]
+}
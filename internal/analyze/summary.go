@@ -0,0 +1,87 @@
+package analyze
+
+import (
+	"math"
+	"slices"
+
+	"gonum.org/v1/gonum/stat"
+
+	"github.com/spegel-org/benchmark/internal/measure"
+)
+
+// Summary are the statistics of pull durations in seconds or throughput in MB/s.
+type Summary struct {
+	Count  int     `json:"count"`
+	Min    float64 `json:"min"`
+	P25    float64 `json:"p25"`
+	Median float64 `json:"median"`
+	P75    float64 `json:"p75"`
+	P90    float64 `json:"p90"`
+	P95    float64 `json:"p95"`
+	P99    float64 `json:"p99"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stdDev"`
+}
+
+// BenchmarkSummary are the summary statistics of a single benchmark in a suite.
+type BenchmarkSummary struct {
+	Suite     string  `json:"suite"`
+	Benchmark string  `json:"benchmark"`
+	Create    Summary `json:"create"`
+	Update    Summary `json:"update"`
+	// CreateThroughput and UpdateThroughput are nil when the image size is not known.
+	CreateThroughput *Summary `json:"createThroughput,omitempty"`
+	UpdateThroughput *Summary `json:"updateThroughput,omitempty"`
+	// ThroughputSource is either nominal when computed from the size in the benchmark key or compressed when fetched from the registry.
+	ThroughputSource string `json:"throughputSource,omitempty"`
+}
+
+func summarizeBenchmark(suiteName, benchmarkName string, benchmark measure.Benchmark) BenchmarkSummary {
+	return BenchmarkSummary{
+		Suite:     suiteName,
+		Benchmark: benchmarkName,
+		Create:    summarize(durations(benchmark.Create.Samples)),
+		Update:    summarize(durations(benchmark.Update.Samples)),
+	}
+}
+
+func durations(samples []measure.Sample) []float64 {
+	data := []float64{}
+	for _, sample := range samples {
+		data = append(data, sample.Duration.Seconds())
+	}
+	return data
+}
+
+func summarize(data []float64) Summary {
+	if len(data) == 0 {
+		return Summary{}
+	}
+	sorted := slices.Clone(data)
+	slices.Sort(sorted)
+	summary := Summary{
+		Count:  len(sorted),
+		Min:    sorted[0],
+		P25:    quantile(0.25, sorted),
+		Median: quantile(0.5, sorted),
+		P75:    quantile(0.75, sorted),
+		P90:    quantile(0.9, sorted),
+		P95:    quantile(0.95, sorted),
+		P99:    quantile(0.99, sorted),
+		Max:    sorted[len(sorted)-1],
+		Mean:   stat.Mean(sorted, nil),
+	}
+	if len(sorted) > 1 {
+		summary.StdDev = stat.StdDev(sorted, nil)
+	}
+	return summary
+}
+
+// quantile linearly interpolates between the closest ranks of the sorted data.
+func quantile(p float64, sorted []float64) float64 {
+	pos := p * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(pos-float64(lower))
+}
@@ -0,0 +1,152 @@
+package analyze
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"github.com/spegel-org/benchmark/internal/measure"
+)
+
+const (
+	ImageSizeFromKey      = "key"
+	ImageSizeFromRegistry = "registry"
+)
+
+// The throughput computed from the size in the benchmark key is nominal, as it is not the bytes transferred.
+const (
+	ThroughputNominal    = "nominal"
+	ThroughputCompressed = "compressed"
+)
+
+const megabyte = 1024 * 1024
+
+// imageSizes resolves image sizes from the benchmark key or the registry.
+type imageSizes struct {
+	cache  map[string]int64
+	source string
+}
+
+func newImageSizes(source string) (*imageSizes, error) {
+	if source != ImageSizeFromKey && source != ImageSizeFromRegistry {
+		return nil, fmt.Errorf("unknown image size source %s", source)
+	}
+	return &imageSizes{
+		cache:  map[string]int64{},
+		source: source,
+	}, nil
+}
+
+func (s *imageSizes) size(ctx context.Context, benchmarkKey, image string) (int64, bool, error) {
+	if s.source == ImageSizeFromKey {
+		pk := parseBenchmarkKey(benchmarkKey)
+		if !pk.ok || pk.size == 0 {
+			return 0, false, nil
+		}
+		return int64(pk.size), true, nil
+	}
+	if image == "" {
+		return 0, false, nil
+	}
+	if size, ok := s.cache[image]; ok {
+		return size, true, nil
+	}
+	size, err := compressedImageSize(ctx, image)
+	if err != nil {
+		return 0, false, fmt.Errorf("could not get size of image %s: %w", image, err)
+	}
+	s.cache[image] = size
+	return size, true, nil
+}
+
+// compressedImageSize returns the bytes transferred when pulling the image.
+func compressedImageSize(ctx context.Context, image string) (int64, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return 0, err
+	}
+	img, err := remote.Image(ref, remote.WithContext(ctx), remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	if err != nil {
+		return 0, err
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return 0, err
+	}
+	size := manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		size += layer.Size
+	}
+	return size, nil
+}
+
+func summarizeThroughput(ctx context.Context, sizes *imageSizes, summary *BenchmarkSummary, benchmark measure.Benchmark) error {
+	create, err := phaseThroughput(ctx, sizes, summary.Benchmark, benchmark.Create)
+	if err != nil {
+		return err
+	}
+	update, err := phaseThroughput(ctx, sizes, summary.Benchmark, benchmark.Update)
+	if err != nil {
+		return err
+	}
+	summary.CreateThroughput = create
+	summary.UpdateThroughput = update
+	if create == nil && update == nil {
+		return nil
+	}
+	summary.ThroughputSource = ThroughputCompressed
+	if sizes.source == ImageSizeFromKey {
+		summary.ThroughputSource = ThroughputNominal
+	}
+	return nil
+}
+
+func phaseThroughput(ctx context.Context, sizes *imageSizes, benchmarkKey string, measurement measure.Measurement) (*Summary, error) {
+	size, ok, err := sizes.size(ctx, benchmarkKey, measurement.Image)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, nil
+	}
+	summary := summarize(throughputs(measurement.Samples, size))
+	return &summary, nil
+}
+
+// throughputs returns the throughput of each sample in MB/s.
+func throughputs(samples []measure.Sample, size int64) []float64 {
+	data := []float64{}
+	for _, sample := range samples {
+		if sample.Duration <= 0 {
+			continue
+		}
+		data = append(data, float64(size)/megabyte/sample.Duration.Seconds())
+	}
+	return data
+}
+
+// throughputSummaries swaps in the throughput summaries so that they can be drawn as a box plot.
+func throughputSummaries(summaries []BenchmarkSummary) []BenchmarkSummary {
+	result := []BenchmarkSummary{}
+	for _, s := range summaries {
+		if s.CreateThroughput == nil && s.UpdateThroughput == nil {
+			continue
+		}
+		t := BenchmarkSummary{
+			Suite:            s.Suite,
+			Benchmark:        s.Benchmark,
+			ThroughputSource: s.ThroughputSource,
+		}
+		if s.CreateThroughput != nil {
+			t.Create = *s.CreateThroughput
+		}
+		if s.UpdateThroughput != nil {
+			t.Update = *s.UpdateThroughput
+		}
+		result = append(result, t)
+	}
+	return result
+}
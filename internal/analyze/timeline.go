@@ -0,0 +1,100 @@
+package analyze
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/go-echarts/go-echarts/v2/charts"
+	"github.com/go-echarts/go-echarts/v2/opts"
+
+	"github.com/spegel-org/benchmark/internal/measure"
+)
+
+const timelineStack = "timeline"
+
+func phaseSamples(benchmark measure.Benchmark, phase string) []measure.Sample {
+	if phase == "update" {
+		return benchmark.Update.Samples
+	}
+	return benchmark.Create.Samples
+}
+
+// newTimeline draws a bar per pull from when it started to when it stopped. Old suites without start times are skipped.
+func newTimeline(suites []measure.Suite, suiteNames []string, benchmarkName, phase string) (*charts.Bar, bool) {
+	categories := []string{}
+	suiteSamples := [][]measure.Sample{}
+	for _, suite := range suites {
+		samples := []measure.Sample{}
+		for _, sample := range phaseSamples(suite.Benchmarks[benchmarkName], phase) {
+			if sample.Start.IsZero() {
+				continue
+			}
+			samples = append(samples, sample)
+		}
+		slices.SortStableFunc(samples, func(a, b measure.Sample) int {
+			return a.Start.Compare(b.Start)
+		})
+		for i, sample := range samples {
+			categories = append(categories, timelineCategory(suite.Name, sample.Node, i))
+		}
+		suiteSamples = append(suiteSamples, samples)
+	}
+	if len(categories) == 0 {
+		return nil, false
+	}
+
+	bar := charts.NewBar()
+	bar.SetGlobalOptions(
+		charts.WithInitializationOpts(opts.Initialization{Height: fmt.Sprintf("%dpx", max(500, 150+20*len(categories)))}),
+		charts.WithTitleOpts(opts.Title{Title: fmt.Sprintf("%s %s timeline", benchmarkName, phase)}),
+		charts.WithGridOpts(opts.Grid{Left: "200", Bottom: "60"}),
+		charts.WithXAxisOpts(opts.XAxis{Type: "value", Name: "Seconds since first pull", NameLocation: "middle", NameGap: 30}),
+		charts.WithYAxisOpts(opts.YAxis{Type: "category", Inverse: opts.Bool(true)}),
+		charts.WithLegendOpts(opts.Legend{Type: "scroll", Left: "center", Bottom: "0", Data: suiteNames}),
+		charts.WithAnimation(false),
+	)
+	bar.SetXAxis(categories).XYReversal()
+
+	pos := 0
+	for i, samples := range suiteSamples {
+		if len(samples) == 0 {
+			continue
+		}
+		offsets := emptyBarData(len(categories))
+		durations := emptyBarData(len(categories))
+		first := samples[0].Start
+		for j, sample := range samples {
+			offsets[pos+j] = opts.BarData{Value: sample.Start.Sub(first).Seconds()}
+			durations[pos+j] = opts.BarData{Value: sample.Duration.Seconds()}
+		}
+		pos += len(samples)
+		name := suites[i].Name
+		// The offset is drawn transparent so that the duration bar starts when the pull started.
+		bar.AddSeries(name+" offset", offsets,
+			charts.WithBarChartOpts(opts.BarChart{Stack: timelineStack}),
+			charts.WithItemStyleOpts(opts.ItemStyle{Color: "transparent"}),
+		)
+		bar.AddSeries(name, durations,
+			charts.WithBarChartOpts(opts.BarChart{Stack: timelineStack}),
+			charts.WithItemStyleOpts(itemStyle(slices.Index(suiteNames, name))),
+		)
+	}
+	return bar, true
+}
+
+// timelineCategory includes the index to keep labels unique when a node pulled more than once.
+func timelineCategory(suiteName, nodeName string, i int) string {
+	if nodeName == "" {
+		nodeName = "pull"
+	}
+	return suiteName + " " + nodeName + " #" + strconv.Itoa(i+1)
+}
+
+func emptyBarData(n int) []opts.BarData {
+	data := make([]opts.BarData, n)
+	for i := range data {
+		data[i] = opts.BarData{Value: "-"}
+	}
+	return data
+}
@@ -3,21 +3,31 @@ package generate
 import (
 	"context"
 	"errors"
+	"os"
 	"time"
 
 	"github.com/c2h5oh/datasize"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/daemon"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/match"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/random"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/types"
 )
 
-const pauseImgName = "registry.k8s.io/pause:3.7"
+const (
+	pauseImgName = "registry.k8s.io/pause:3.7"
+	// refNameAnnotation is the OCI layout annotation holding the image name, which match.Name matches against.
+	refNameAnnotation = "org.opencontainers.image.ref.name"
+)
 
-func Generate(ctx context.Context, imgName string, layerCount int, imageSize datasize.ByteSize) error {
+// Generate creates an image with random layers on top of the pause image. The image is written to the OCI layout
+// when a layout path is given, otherwise it is written to the Docker daemon.
+func Generate(ctx context.Context, imgName string, layerCount int, imageSize datasize.ByteSize, layoutPath string) error {
 	layerSize, err := layerSize(layerCount, imageSize)
 	if err != nil {
 		return err
@@ -50,6 +60,9 @@ func Generate(ctx context.Context, imgName string, layerCount int, imageSize dat
 	if err != nil {
 		return err
 	}
+	if layoutPath != "" {
+		return writeLayout(layoutPath, tag, img)
+	}
 	_, err = daemon.Write(tag, img, daemon.WithContext(ctx))
 	if err != nil {
 		return err
@@ -57,6 +70,20 @@ func Generate(ctx context.Context, imgName string, layerCount int, imageSize dat
 	return nil
 }
 
+// writeLayout adds the image to the OCI layout, replacing any image with the same name.
+func writeLayout(layoutPath string, tag name.Tag, img v1.Image) error {
+	p, err := layout.FromPath(layoutPath)
+	if errors.Is(err, os.ErrNotExist) {
+		p, err = layout.Write(layoutPath, empty.Index)
+	}
+	if err != nil {
+		return err
+	}
+	return p.ReplaceImage(img, match.Name(tag.String()), layout.WithAnnotations(map[string]string{
+		refNameAnnotation: tag.String(),
+	}))
+}
+
 func layerSize(layerCount int, imageSize datasize.ByteSize) (int64, error) {
 	layerSize := imageSize.Bytes() / uint64(layerCount)
 	if layerSize*uint64(layerCount) != imageSize.Bytes() {
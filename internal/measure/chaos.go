@@ -0,0 +1,280 @@
+package measure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Fault is a disruption injected into the cluster while an image pull is measured.
+type Fault interface {
+	Name() string
+	// Inject disrupts the cluster and returns a description of what was done.
+	Inject(ctx context.Context, cs kubernetes.Interface) (string, error)
+	// Recover undoes any disruption which the cluster does not recover from by itself.
+	Recover(ctx context.Context, cs kubernetes.Interface) error
+}
+
+// ChaosStep injects a fault at a set time after the update measurement has started.
+type ChaosStep struct {
+	Fault Fault
+	After time.Duration
+}
+
+// ChaosEvent records a fault injected during a measurement.
+// Faults which were not injected before the measurement completed are recorded as skipped with the planned delay.
+type ChaosEvent struct {
+	Time    time.Time     `json:"time"`
+	Fault   string        `json:"fault"`
+	Message string        `json:"message,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	After   time.Duration `json:"after"`
+	Skipped bool          `json:"skipped,omitempty"`
+}
+
+var _ Fault = DeleteSpegelPods{}
+
+// DeleteSpegelPods deletes a percentage of the Spegel pods, removing peers which may be serving layers.
+type DeleteSpegelPods struct {
+	Selector string
+	Percent  int
+}
+
+func (DeleteSpegelPods) Name() string {
+	return "delete-spegel-pods"
+}
+
+func (f DeleteSpegelPods) Inject(ctx context.Context, cs kubernetes.Interface) (string, error) {
+	if f.Selector == "" {
+		return "", errors.New("selector for Spegel pods cannot be empty")
+	}
+	podList, err := cs.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: f.Selector})
+	if err != nil {
+		return "", err
+	}
+	if len(podList.Items) == 0 {
+		return "", fmt.Errorf("no Spegel pods found matching selector %s", f.Selector)
+	}
+	count := max((len(podList.Items)*f.Percent+99)/100, 1)
+	deleted := []string{}
+	errs := []error{}
+	for _, i := range rand.Perm(len(podList.Items))[:count] {
+		pod := podList.Items[i]
+		err := cs.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		deleted = append(deleted, fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
+	}
+	return fmt.Sprintf("deleted %d of %d pods %s", len(deleted), len(podList.Items), strings.Join(deleted, ", ")), errors.Join(errs...)
+}
+
+func (DeleteSpegelPods) Recover(_ context.Context, _ kubernetes.Interface) error {
+	return nil
+}
+
+var _ Fault = &DrainNode{}
+
+// DrainNode cordons a node and evicts all pods not managed by a DaemonSet, the node is uncordoned when recovering.
+// Spegel runs as a DaemonSet so its pod on the node is deleted when the selector is set. The DaemonSet
+// recreates the pod as it tolerates the cordon, so the peer is only unavailable until the new pod is ready.
+type DrainNode struct {
+	NodeName       string
+	SpegelSelector string
+	cordoned       bool
+}
+
+func (*DrainNode) Name() string {
+	return "drain-node"
+}
+
+func (f *DrainNode) Inject(ctx context.Context, cs kubernetes.Interface) (string, error) {
+	err := f.setUnschedulable(ctx, cs, true)
+	if err != nil {
+		return "", err
+	}
+	podList, err := cs.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{FieldSelector: fmt.Sprintf("spec.nodeName=%s", f.NodeName)})
+	if err != nil {
+		return "", err
+	}
+	evicted := 0
+	errs := []error{}
+	for _, pod := range podList.Items {
+		if !isEvictable(pod) {
+			continue
+		}
+		eviction := &policyv1.Eviction{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      pod.Name,
+				Namespace: pod.Namespace,
+			},
+		}
+		err := cs.PolicyV1().Evictions(pod.Namespace).Evict(ctx, eviction)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not evict pod %s/%s: %w", pod.Namespace, pod.Name, err))
+			continue
+		}
+		evicted++
+	}
+	msg := fmt.Sprintf("cordoned node %s and evicted %d pods", f.NodeName, evicted)
+	if f.SpegelSelector == "" {
+		return msg, errors.Join(errs...)
+	}
+	spegelPodList, err := cs.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: f.SpegelSelector, FieldSelector: fmt.Sprintf("spec.nodeName=%s", f.NodeName)})
+	if err != nil {
+		return msg, errors.Join(append(errs, err)...)
+	}
+	deleted := []string{}
+	for _, pod := range spegelPodList.Items {
+		if pod.Spec.NodeName != f.NodeName {
+			continue
+		}
+		err := cs.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not delete Spegel pod %s/%s: %w", pod.Namespace, pod.Name, err))
+			continue
+		}
+		deleted = append(deleted, fmt.Sprintf("%s/%s", pod.Namespace, pod.Name))
+	}
+	return fmt.Sprintf("%s, deleted Spegel pods %s", msg, strings.Join(deleted, ", ")), errors.Join(errs...)
+}
+
+func (f *DrainNode) Recover(ctx context.Context, cs kubernetes.Interface) error {
+	if !f.cordoned {
+		return nil
+	}
+	return f.setUnschedulable(ctx, cs, false)
+}
+
+func (f *DrainNode) setUnschedulable(ctx context.Context, cs kubernetes.Interface, unschedulable bool) error {
+	node, err := cs.CoreV1().Nodes().Get(ctx, f.NodeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	// A node which was already cordoned is left as is.
+	if node.Spec.Unschedulable == unschedulable {
+		return nil
+	}
+	node.Spec.Unschedulable = unschedulable
+	_, err = cs.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+	f.cordoned = unschedulable
+	return nil
+}
+
+// isEvictable returns false for pods which are not evicted when draining a node.
+func isEvictable(pod corev1.Pod) bool {
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return false
+	}
+	if _, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]; ok {
+		return false
+	}
+	for _, ref := range pod.OwnerReferences {
+		if ref.Kind == "DaemonSet" {
+			return false
+		}
+	}
+	return true
+}
+
+// chaosRun injects the faults of the chaos steps in the background while a measurement runs.
+type chaosRun struct {
+	cs       kubernetes.Interface
+	cancel   context.CancelFunc
+	start    time.Time
+	steps    []ChaosStep
+	injected []bool
+	events   []ChaosEvent
+	wg       sync.WaitGroup
+	mx       sync.Mutex
+}
+
+func startChaos(ctx context.Context, cs kubernetes.Interface, steps []ChaosStep) *chaosRun {
+	ctx, cancel := context.WithCancel(ctx)
+	c := &chaosRun{
+		cs:       cs,
+		cancel:   cancel,
+		start:    time.Now(),
+		steps:    steps,
+		injected: make([]bool, len(steps)),
+	}
+	for i, step := range steps {
+		c.wg.Go(func() {
+			timer := time.NewTimer(step.After)
+			defer timer.Stop()
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+
+			log := logr.FromContextOrDiscard(ctx).WithValues("fault", step.Fault.Name())
+			log.Info("injecting fault")
+			event := ChaosEvent{
+				Time:  time.Now(),
+				Fault: step.Fault.Name(),
+				After: time.Since(c.start),
+			}
+			msg, err := step.Fault.Inject(ctx, cs)
+			event.Message = msg
+			if err != nil {
+				log.Error(err, "could not inject fault")
+				event.Error = err.Error()
+			}
+			c.mx.Lock()
+			defer c.mx.Unlock()
+			c.injected[i] = true
+			c.events = append(c.events, event)
+		})
+	}
+	return c
+}
+
+// stop cancels faults which have not been injected yet, recovers from all faults and returns the events.
+// Recovery is done even if the context is cancelled so that the cluster is not left disrupted.
+func (c *chaosRun) stop(ctx context.Context) ([]ChaosEvent, error) {
+	c.cancel()
+	c.wg.Wait()
+	now := time.Now()
+	for i, step := range c.steps {
+		if c.injected[i] {
+			continue
+		}
+		logr.FromContextOrDiscard(ctx).Info("measurement completed before fault was injected", "fault", step.Fault.Name(), "after", step.After, "elapsed", now.Sub(c.start))
+		c.events = append(c.events, ChaosEvent{
+			Time:    now,
+			Fault:   step.Fault.Name(),
+			Message: fmt.Sprintf("measurement completed after %s before the fault was injected", now.Sub(c.start).Round(time.Millisecond)),
+			After:   step.After,
+			Skipped: true,
+		})
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
+	defer cancel()
+	errs := []error{}
+	for _, step := range c.steps {
+		err := step.Fault.Recover(ctx, c.cs)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not recover from fault %s: %w", step.Fault.Name(), err))
+		}
+	}
+	slices.SortFunc(c.events, func(a, b ChaosEvent) int {
+		return a.Time.Compare(b.Time)
+	})
+	return c.events, errors.Join(errs...)
+}
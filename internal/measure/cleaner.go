@@ -0,0 +1,518 @@
+package measure
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	clearImageName     = "spegel-clear-image"
+	clearImageMarker   = "spegel-clear-image:"
+	clearImageAttempts = 3
+)
+
+// ImageCleaner removes images from the container runtime of a node.
+type ImageCleaner interface {
+	// Name identifies the cleaner.
+	Name() string
+	// ProbeCommand checks that the runtime can be reached.
+	ProbeCommand() string
+	// Command removes the images, an empty command means that images are removed externally.
+	Command(images []string) string
+	// VerifyCommand prints every image or layer that remains on a separate line.
+	VerifyCommand(images, layers []string) string
+}
+
+// CrictlCleaner removes images through the CRI with crictl.
+type CrictlCleaner struct{}
+
+func (CrictlCleaner) Name() string {
+	return "crictl"
+}
+
+func (CrictlCleaner) ProbeCommand() string {
+	return requireTool("crictl") + "crictl version"
+}
+
+func (CrictlCleaner) Command(images []string) string {
+	// Removing a missing image is an error in crictl.
+	return requireTool("crictl") + fmt.Sprintf(`for img in %s; do if out=$(crictl inspecti "$img" 2>&1); then crictl rmi "$img" || exit 1; elif ! echo "$out" | grep -q "no such image"; then echo "$out"; exit 1; fi; done`, strings.Join(images, " "))
+}
+
+// VerifyCommand only checks image references as layers are not visible through the CRI.
+func (CrictlCleaner) VerifyCommand(images, layers []string) string {
+	return requireTool("crictl") + fmt.Sprintf(`for img in %s; do if out=$(crictl inspecti "$img" 2>&1); then echo "$img"; elif ! echo "$out" | grep -q "no such image"; then echo "$out" >&2; exit 1; fi; done`, strings.Join(images, " "))
+}
+
+// CtrCleaner removes images directly from containerd with ctr.
+type CtrCleaner struct {
+	// Namespace is the containerd namespace used by the Kubelet.
+	Namespace string
+}
+
+func (CtrCleaner) Name() string {
+	return "ctr"
+}
+
+func (c CtrCleaner) ProbeCommand() string {
+	return requireTool("ctr") + fmt.Sprintf("ctr -n %s version", c.Namespace)
+}
+
+func (c CtrCleaner) Command(images []string) string {
+	// All references to the same target have to be removed for the image to be deleted.
+	return requireTool("ctr") + fmt.Sprintf(`for img in %[2]s; do refs=$(ctr -n %[1]s images ls "name==$img") || exit 1; for digest in $(echo "$refs" | awk 'NR>1 {print $3}'); do targets=$(ctr -n %[1]s images ls -q "target.digest==$digest") || exit 1; echo "$targets" | xargs -r ctr -n %[1]s images rm || exit 1; done; done`, c.Namespace, strings.Join(images, " "))
+}
+
+// VerifyCommand also checks layers, skipping layers still referenced by other content.
+func (c CtrCleaner) VerifyCommand(images, layers []string) string {
+	cmd := requireTool("ctr") + fmt.Sprintf(`for img in %[2]s; do ctr -n %[1]s images ls -q "name==$img" || exit 1; done`, c.Namespace, strings.Join(images, " "))
+	if len(layers) == 0 {
+		return cmd
+	}
+	return cmd + fmt.Sprintf(`; content=$(ctr -n %[1]s content ls) || exit 1; for layer in %[2]s; do if echo "$content" | grep -q "^$layer" && ! echo "$content" | grep -q "=$layer"; then echo "$layer"; fi; done`, c.Namespace, strings.Join(layers, " "))
+}
+
+func requireTool(tool string) string {
+	return fmt.Sprintf(`command -v %[1]s > /dev/null || { echo "%[1]s not found" >&2; exit 1; }; `, tool)
+}
+
+// NoopCleaner does not remove any images.
+type NoopCleaner struct{}
+
+func (NoopCleaner) Name() string {
+	return "none"
+}
+
+func (NoopCleaner) ProbeCommand() string {
+	return ""
+}
+
+func (NoopCleaner) Command(images []string) string {
+	return ""
+}
+
+func (NoopCleaner) VerifyCommand(images, layers []string) string {
+	return ""
+}
+
+// GarbageCollector triggers containerd garbage collection after images are removed.
+type GarbageCollector struct {
+	// Namespace is the containerd namespace used by the Kubelet.
+	Namespace string
+	// Root is the containerd root directory used to measure reclaimed disk space.
+	Root string
+}
+
+// Command deletes a lease with sync, which waits for unreferenced content to be removed.
+func (g GarbageCollector) Command() string {
+	return requireTool("ctr") + fmt.Sprintf(`lease=$(ctr -n %[1]s leases create) && ctr -n %[1]s leases delete --sync "$lease"`, g.Namespace)
+}
+
+// UsageCommand prints the bytes used by content and snapshots.
+func (g GarbageCollector) UsageCommand() string {
+	return fmt.Sprintf(`du -sk %[1]s/io.containerd.content.v1.content %[1]s/io.containerd.snapshotter.v1.* 2> /dev/null | awk '{s+=$1} END {print s*1024}'`, g.Root)
+}
+
+// NodeCleanup is the result of removing images from a single node.
+type NodeCleanup struct {
+	Node           string   `json:"node"`
+	Message        string   `json:"message,omitempty"`
+	Remaining      []string `json:"remaining,omitempty"`
+	ReclaimedBytes int64    `json:"reclaimedBytes,omitempty"`
+	Success        bool     `json:"success"`
+}
+
+// removeImages clears the images from the nodes, retrying as content may be removed asynchronously.
+func removeImages(ctx context.Context, cs kubernetes.Interface, dc dynamic.Interface, w Workload, images, nodeNames []string) ([]NodeCleanup, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	layers, err := imageLayers(ctx, w.Keychain, images)
+	if err != nil {
+		log.Error(err, "could not resolve image layers, only image references will be verified")
+	}
+	var results []NodeCleanup
+	reclaimed := map[string]int64{}
+	for attempt := 1; attempt <= clearImageAttempts; attempt++ {
+		results, err = clearImages(ctx, cs, dc, w, images, layers, nodeNames)
+		if err != nil {
+			return nil, err
+		}
+		for i, result := range results {
+			reclaimed[result.Node] += result.ReclaimedBytes
+			results[i].ReclaimedBytes = reclaimed[result.Node]
+		}
+		remaining := remainingNodes(results)
+		if len(remaining) == 0 {
+			break
+		}
+		if attempt == clearImageAttempts {
+			return results, fmt.Errorf("images remain on nodes %s after %d attempts", strings.Join(remaining, ", "), attempt)
+		}
+		log.Info("images remain after cleanup, retrying", "nodes", remaining, "attempt", attempt)
+	}
+	if w.Cleaner.Command(images) == "" {
+		return results, nil
+	}
+	if w.GarbageCollector != nil {
+		for _, result := range results {
+			log.Info("reclaimed disk space", "node", result.Node, "bytes", result.ReclaimedBytes)
+		}
+	}
+	err = waitForNodeImagesRemoved(ctx, cs, images, nodeNames)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func remainingNodes(results []NodeCleanup) []string {
+	nodes := []string{}
+	for _, result := range results {
+		if len(result.Remaining) == 0 {
+			continue
+		}
+		nodes = append(nodes, result.Node)
+	}
+	return nodes
+}
+
+// imageLayers resolves the layer digests of the images from the registry.
+func imageLayers(ctx context.Context, keychain authn.Keychain, images []string) ([]string, error) {
+	layers := []string{}
+	for _, image := range images {
+		ref, err := name.ParseReference(image)
+		if err != nil {
+			return nil, err
+		}
+		img, err := remote.Image(ref, remote.WithContext(ctx), remote.WithAuthFromKeychain(keychain))
+		if err != nil {
+			return nil, err
+		}
+		imgLayers, err := img.Layers()
+		if err != nil {
+			return nil, err
+		}
+		for _, layer := range imgLayers {
+			digest, err := layer.Digest()
+			if err != nil {
+				return nil, err
+			}
+			layers = append(layers, digest.String())
+		}
+	}
+	return layers, nil
+}
+
+// waitForNodeImagesRemoved waits for the images to disappear from the node status.
+func waitForNodeImagesRemoved(ctx context.Context, cs kubernetes.Interface, images, nodeNames []string) error {
+	var remaining []string
+	err := wait.PollUntilContextTimeout(ctx, 5*time.Second, 2*time.Minute, true, func(ctx context.Context) (done bool, err error) {
+		nodeList, err := cs.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		remaining = nodeImagesRemaining(nodeList.Items, images, nodeNames)
+		return len(remaining) == 0, nil
+	})
+	if err != nil && len(remaining) > 0 {
+		return fmt.Errorf("images still reported in node status: %s", strings.Join(remaining, ", "))
+	}
+	return err
+}
+
+func nodeImagesRemaining(nodes []corev1.Node, images, nodeNames []string) []string {
+	remaining := []string{}
+	for _, node := range nodes {
+		if len(nodeNames) > 0 && !slices.Contains(nodeNames, node.Name) {
+			continue
+		}
+		for _, nodeImage := range node.Status.Images {
+			for _, image := range images {
+				if !slices.Contains(nodeImage.Names, image) {
+					continue
+				}
+				remaining = append(remaining, fmt.Sprintf("%s on %s", image, node.Name))
+			}
+		}
+	}
+	return remaining
+}
+
+// clearImages removes the images from the given nodes, or from all nodes when none are given.
+func clearImages(ctx context.Context, cs kubernetes.Interface, dc dynamic.Interface, w Workload, images, layers, nodeNames []string) ([]NodeCleanup, error) {
+	namespace := w.Namespace
+	cleaner := w.Cleaner
+	log := logr.FromContextOrDiscard(ctx).WithValues("cleaner", cleaner.Name())
+	command := cleaner.Command(images)
+	if command == "" {
+		log.Info("skipping image cleanup")
+		return nil, nil
+	}
+	log.Info("clearing images", "nodes", nodeNames)
+
+	gcCommand := ""
+	usageCommand := ""
+	if w.GarbageCollector != nil {
+		gcCommand = w.GarbageCollector.Command()
+		usageCommand = w.GarbageCollector.UsageCommand()
+	}
+
+	script := fmt.Sprintf(`#!/bin/sh
+status=success
+if [ -n "$PROBE_COMMAND" ] && ! chroot /host /bin/sh -c "$PROBE_COMMAND" > /dev/null; then
+  status=failure
+fi
+if [ -n "$GC_COMMAND" ] && [ "$status" = "success" ]; then
+  before=$(chroot /host /bin/sh -c "$USAGE_COMMAND")
+fi
+if [ "$status" = "success" ] && ! chroot /host /bin/sh -c "$CLEAR_COMMAND"; then
+  status=failure
+fi
+if [ -n "$GC_COMMAND" ] && [ "$status" = "success" ]; then
+  if chroot /host /bin/sh -c "$GC_COMMAND"; then
+    after=$(chroot /host /bin/sh -c "$USAGE_COMMAND")
+    echo "%[1]s reclaimed $((${before:-0} - ${after:-0}))"
+  else
+    status=failure
+  fi
+fi
+if [ -n "$VERIFY_COMMAND" ] && [ "$status" = "success" ]; then
+  if remaining=$(chroot /host /bin/sh -c "$VERIFY_COMMAND"); then
+    for ref in $remaining; do echo "%[1]s remaining $ref"; done
+  else
+    status=failure
+  fi
+fi
+echo "%[1]s $status"
+touch /tmp/done
+trap 'exit 0' TERM
+sleep infinity &
+wait $!`, clearImageMarker)
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   clearImageName,
+			Labels: w.labels(clearImageName),
+		},
+		Data: map[string]string{
+			"run.sh": script,
+		},
+	}
+	_, err := cs.CoreV1().ConfigMaps(namespace).Create(ctx, cm, metav1.CreateOptions{})
+	if kerrors.IsAlreadyExists(err) {
+		_, err = cs.CoreV1().ConfigMaps(namespace).Update(ctx, cm, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	filePerm := int32(0o755)
+	ds := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   clearImageName,
+			Labels: w.labels(clearImageName),
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": clearImageName},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: w.labels(clearImageName),
+				},
+				Spec: corev1.PodSpec{
+					Affinity: nodeNameAffinity(nodeNames),
+					Containers: []corev1.Container{
+						{
+							Name:            "clear",
+							Image:           "docker.io/library/alpine:3.21.3@sha256:a8560b36e8b8210634f77d9f7f9efd7ffa463e380b75e2e74aff4511df3ef88c",
+							ImagePullPolicy: "IfNotPresent",
+							Command:         []string{"/scripts/run.sh"},
+							Stdin:           true,
+							Env: []corev1.EnvVar{
+								{
+									Name:  "PROBE_COMMAND",
+									Value: cleaner.ProbeCommand(),
+								},
+								{
+									Name:  "CLEAR_COMMAND",
+									Value: command,
+								},
+								{
+									Name:  "VERIFY_COMMAND",
+									Value: cleaner.VerifyCommand(images, layers),
+								},
+								{
+									Name:  "GC_COMMAND",
+									Value: gcCommand,
+								},
+								{
+									Name:  "USAGE_COMMAND",
+									Value: usageCommand,
+								},
+							},
+							// The pod is only ready once the cleanup has run.
+							ReadinessProbe: &corev1.Probe{
+								ProbeHandler: corev1.ProbeHandler{
+									Exec: &corev1.ExecAction{
+										Command: []string{"cat", "/tmp/done"},
+									},
+								},
+								PeriodSeconds: 1,
+							},
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      "scripts",
+									MountPath: "/scripts/run.sh",
+									SubPath:   "run.sh",
+								},
+								{
+									Name:      "host-root",
+									MountPath: "/host",
+								},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "scripts",
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{
+										Name: clearImageName,
+									},
+									DefaultMode: &filePerm,
+								},
+							},
+						},
+						{
+							Name: "host-root",
+							VolumeSource: corev1.VolumeSource{
+								HostPath: &corev1.HostPathVolumeSource{
+									Path: "/",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	// Pods of a previous cleanup may still be terminating.
+	err = ignoreNotFound(cs.AppsV1().DaemonSets(namespace).Delete(ctx, clearImageName, metav1.DeleteOptions{}))
+	if err != nil {
+		return nil, err
+	}
+	err = waitForPodsDeleted(ctx, cs, namespace, fmt.Sprintf("app=%s", clearImageName))
+	if err != nil {
+		return nil, err
+	}
+	ds, err = cs.AppsV1().DaemonSets(namespace).Create(ctx, ds, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		err := cs.AppsV1().DaemonSets(namespace).Delete(ctx, ds.Name, metav1.DeleteOptions{})
+		if err != nil {
+			log.Error(err, "could not delete image cleanup daemonset")
+		}
+		err = cs.CoreV1().ConfigMaps(namespace).Delete(ctx, cm.Name, metav1.DeleteOptions{})
+		if err != nil {
+			log.Error(err, "could not delete image cleanup config map")
+		}
+	}()
+
+	err = waitForCurrent(ctx, dc, daemonSetGVR, namespace, ds.Name, 10*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	podList, err := cs.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: fmt.Sprintf("app=%s", clearImageName)})
+	if err != nil {
+		return nil, err
+	}
+	results := []NodeCleanup{}
+	failedNodes := []string{}
+	for _, pod := range controlledPods(podList.Items, ds) {
+		b, err := cs.CoreV1().Pods(namespace).GetLogs(pod.Name, &corev1.PodLogOptions{Container: "clear"}).DoRaw(ctx)
+		if err != nil {
+			return nil, err
+		}
+		result := parseCleanupLog(pod.Spec.NodeName, string(b))
+		if !result.Success {
+			log.Info("image cleanup failed", "node", result.Node, "message", result.Message)
+			failedNodes = append(failedNodes, result.Node)
+		}
+		results = append(results, result)
+	}
+	if len(failedNodes) > 0 {
+		return results, fmt.Errorf("image cleanup failed on nodes %s", strings.Join(failedNodes, ", "))
+	}
+	return results, nil
+}
+
+func controlledPods(pods []corev1.Pod, owner metav1.Object) []corev1.Pod {
+	controlled := []corev1.Pod{}
+	for _, pod := range pods {
+		if !metav1.IsControlledBy(&pod, owner) {
+			continue
+		}
+		controlled = append(controlled, pod)
+	}
+	return controlled
+}
+
+// parseCleanupLog parses the output of the cleanup script run on the node.
+func parseCleanupLog(node, output string) NodeCleanup {
+	result := NodeCleanup{
+		Node: node,
+	}
+	lines := []string{}
+	completed := false
+	for line := range strings.Lines(output) {
+		line = strings.TrimSpace(line)
+		status, ok := strings.CutPrefix(line, clearImageMarker)
+		if !ok {
+			if line != "" {
+				lines = append(lines, line)
+			}
+			continue
+		}
+		status = strings.TrimSpace(status)
+		if ref, ok := strings.CutPrefix(status, "remaining "); ok {
+			result.Remaining = append(result.Remaining, ref)
+			continue
+		}
+		if reclaimed, ok := strings.CutPrefix(status, "reclaimed "); ok {
+			n, err := strconv.ParseInt(reclaimed, 10, 64)
+			if err == nil {
+				result.ReclaimedBytes = n
+			}
+			continue
+		}
+		completed = true
+		result.Success = status == "success"
+	}
+	result.Message = strings.Join(lines, "\n")
+	if !completed && result.Message == "" {
+		result.Message = "image cleanup did not complete"
+	}
+	return result
+}
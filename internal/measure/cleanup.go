@@ -0,0 +1,178 @@
+package measure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "spegel-benchmark"
+	runIDLabel     = "benchmark.spegel.dev/run-id"
+	// cleanupTimeout is the time given to delete resources after a benchmark, even when the benchmark was cancelled.
+	cleanupTimeout = 30 * time.Second
+)
+
+// newRunID returns an identifier for all resources created by a single invocation.
+// The random suffix keeps runs started in the same second apart.
+func newRunID() string {
+	return strconv.FormatInt(time.Now().Unix(), 10) + "-" + utilrand.String(5)
+}
+
+// RunCleanup removes all resources left behind by previous benchmark runs. When a run ID is given only
+// resources from that run are removed, otherwise the namespace is also removed if it was created by a benchmark.
+func RunCleanup(ctx context.Context, kubeconfigPath, namespace, runID string) error {
+	cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	if err != nil {
+		return err
+	}
+	cs, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	selector := fmt.Sprintf("%s=%s", managedByLabel, managedByValue)
+	if runID != "" {
+		selector = fmt.Sprintf("%s,%s=%s", selector, runIDLabel, runID)
+	}
+	err = deleteResources(ctx, cs, namespace, selector)
+	if err != nil {
+		return err
+	}
+	if runID != "" {
+		return nil
+	}
+
+	ns, err := cs.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if ns.Labels[managedByLabel] != managedByValue {
+		logr.FromContextOrDiscard(ctx).Info("keeping namespace not created by benchmark", "namespace", namespace)
+		return nil
+	}
+	logr.FromContextOrDiscard(ctx).Info("deleting namespace", "namespace", namespace)
+	err = cs.CoreV1().Namespaces().Delete(ctx, namespace, metav1.DeleteOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// staleSelector selects resources created by benchmark runs other than the given one.
+func staleSelector(runID string) string {
+	return fmt.Sprintf("%s=%s,%s!=%s", managedByLabel, managedByValue, runIDLabel, runID)
+}
+
+// staleRunIDs returns the run IDs of benchmark workloads created by other runs. These are not removed
+// automatically as the other run may still be in progress, instead they are removed by the cleanup command.
+func staleRunIDs(ctx context.Context, cs kubernetes.Interface, namespace, runID string) ([]string, error) {
+	listOpts := metav1.ListOptions{LabelSelector: staleSelector(runID)}
+	objs := []metav1.ObjectMeta{}
+	dsList, err := cs.AppsV1().DaemonSets(namespace).List(ctx, listOpts)
+	if err != nil {
+		return nil, err
+	}
+	for _, ds := range dsList.Items {
+		objs = append(objs, ds.ObjectMeta)
+	}
+	deployList, err := cs.AppsV1().Deployments(namespace).List(ctx, listOpts)
+	if err != nil {
+		return nil, err
+	}
+	for _, deploy := range deployList.Items {
+		objs = append(objs, deploy.ObjectMeta)
+	}
+	jobList, err := cs.BatchV1().Jobs(namespace).List(ctx, listOpts)
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobList.Items {
+		objs = append(objs, job.ObjectMeta)
+	}
+	runIDs := []string{}
+	for _, obj := range objs {
+		if slices.Contains(runIDs, obj.Labels[runIDLabel]) {
+			continue
+		}
+		runIDs = append(runIDs, obj.Labels[runIDLabel])
+	}
+	slices.Sort(runIDs)
+	return runIDs, nil
+}
+
+// deleteResources deletes all benchmark resources matching the selector and waits for their pods to be removed.
+func deleteResources(ctx context.Context, cs kubernetes.Interface, namespace, selector string) error {
+	log := logr.FromContextOrDiscard(ctx)
+	propagation := metav1.DeletePropagationForeground
+	deleteOpts := metav1.DeleteOptions{PropagationPolicy: &propagation}
+	listOpts := metav1.ListOptions{LabelSelector: selector}
+
+	errs := []error{}
+	dsList, err := cs.AppsV1().DaemonSets(namespace).List(ctx, listOpts)
+	if err != nil {
+		return err
+	}
+	for _, ds := range dsList.Items {
+		log.Info("deleting daemonset", "name", ds.Name, "runID", ds.Labels[runIDLabel])
+		errs = append(errs, ignoreNotFound(cs.AppsV1().DaemonSets(namespace).Delete(ctx, ds.Name, deleteOpts)))
+	}
+	deployList, err := cs.AppsV1().Deployments(namespace).List(ctx, listOpts)
+	if err != nil {
+		return err
+	}
+	for _, deploy := range deployList.Items {
+		log.Info("deleting deployment", "name", deploy.Name, "runID", deploy.Labels[runIDLabel])
+		errs = append(errs, ignoreNotFound(cs.AppsV1().Deployments(namespace).Delete(ctx, deploy.Name, deleteOpts)))
+	}
+	jobList, err := cs.BatchV1().Jobs(namespace).List(ctx, listOpts)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobList.Items {
+		log.Info("deleting job", "name", job.Name, "runID", job.Labels[runIDLabel])
+		errs = append(errs, ignoreNotFound(cs.BatchV1().Jobs(namespace).Delete(ctx, job.Name, deleteOpts)))
+	}
+	cmList, err := cs.CoreV1().ConfigMaps(namespace).List(ctx, listOpts)
+	if err != nil {
+		return err
+	}
+	for _, cm := range cmList.Items {
+		log.Info("deleting config map", "name", cm.Name, "runID", cm.Labels[runIDLabel])
+		errs = append(errs, ignoreNotFound(cs.CoreV1().ConfigMaps(namespace).Delete(ctx, cm.Name, deleteOpts)))
+	}
+	secretList, err := cs.CoreV1().Secrets(namespace).List(ctx, listOpts)
+	if err != nil {
+		return err
+	}
+	for _, secret := range secretList.Items {
+		log.Info("deleting secret", "name", secret.Name, "runID", secret.Labels[runIDLabel])
+		errs = append(errs, ignoreNotFound(cs.CoreV1().Secrets(namespace).Delete(ctx, secret.Name, deleteOpts)))
+	}
+	err = errors.Join(errs...)
+	if err != nil {
+		return err
+	}
+	return waitForPodsDeleted(ctx, cs, namespace, selector)
+}
+
+func ignoreNotFound(err error) error {
+	if kerrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
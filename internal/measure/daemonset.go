@@ -0,0 +1,90 @@
+package measure
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DaemonSetScenario rolls out a DaemonSet so that every node pulls the image once.
+type DaemonSetScenario struct{}
+
+func (DaemonSetScenario) Name() string {
+	return "daemonset"
+}
+
+func (DaemonSetScenario) Measure(ctx context.Context, cs kubernetes.Interface, dc dynamic.Interface, w Workload, image string) ([]Sample, error) {
+	logr.FromContextOrDiscard(ctx).Info("measuring pull performance", "image", image)
+	err := rolloutDaemonSet(ctx, cs, dc, w, w.Name, image, nil)
+	if err != nil {
+		return nil, err
+	}
+	return collectSamples(ctx, cs, w.Namespace, fmt.Sprintf("app=%s", w.Name), false)
+}
+
+func (DaemonSetScenario) Cleanup(ctx context.Context, cs kubernetes.Interface, w Workload) error {
+	return cs.AppsV1().DaemonSets(w.Namespace).Delete(ctx, w.Name, metav1.DeleteOptions{})
+}
+
+// rolloutDaemonSet creates or updates the DaemonSet to run the image and waits for the rollout to complete.
+// When node names are given the DaemonSet will only run on those nodes.
+func rolloutDaemonSet(ctx context.Context, cs kubernetes.Interface, dc dynamic.Interface, w Workload, name, image string, nodeNames []string) error {
+	log := logr.FromContextOrDiscard(ctx).WithValues("image", image)
+	namespace := w.Namespace
+	ds, err := cs.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return err
+	}
+	if kerrors.IsNotFound(err) {
+		maxUnavailable := intstr.FromString("20%")
+		ds := &appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: w.labels(name),
+			},
+			Spec: appsv1.DaemonSetSpec{
+				Selector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"app": name},
+				},
+				UpdateStrategy: appsv1.DaemonSetUpdateStrategy{
+					RollingUpdate: &appsv1.RollingUpdateDaemonSet{
+						MaxUnavailable: &maxUnavailable,
+					},
+				},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{
+						Labels: w.labels(name),
+					},
+					Spec: corev1.PodSpec{
+						Affinity:         nodeNameAffinity(nodeNames),
+						ImagePullSecrets: w.ImagePullSecrets,
+						Containers:       []corev1.Container{benchmarkContainer(image)},
+					},
+				},
+			},
+		}
+		_, err = cs.AppsV1().DaemonSets(namespace).Create(ctx, ds, metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+	} else {
+		ds.Spec.Template.Spec.Affinity = nodeNameAffinity(nodeNames)
+		ds.Spec.Template.Spec.Containers[0].Image = image
+		_, err := cs.AppsV1().DaemonSets(namespace).Update(ctx, ds, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Info("waiting for rollout completion")
+	return waitForCurrent(ctx, dc, daemonSetGVR, namespace, name, 30*time.Minute)
+}
@@ -0,0 +1,127 @@
+package measure
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DeploymentScenario scales a Deployment from zero to the configured replica count.
+// Depending on the replica count and spread constraints some nodes will run multiple pods while others run none.
+type DeploymentScenario struct {
+	// TopologyKey is the node label used to spread the replicas.
+	TopologyKey string
+	// Replicas is the replica count the Deployment is scaled to.
+	Replicas int32
+	// MaxSkew is the max skew of the topology spread constraint, zero disables the constraint.
+	MaxSkew int32
+}
+
+func (DeploymentScenario) Name() string {
+	return "deployment"
+}
+
+func (s DeploymentScenario) Measure(ctx context.Context, cs kubernetes.Interface, dc dynamic.Interface, w Workload, image string) ([]Sample, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("image", image, "replicas", s.Replicas)
+	log.Info("measuring pull performance")
+
+	// Make sure the Deployment exists with zero replicas running the image.
+	deploy, err := cs.AppsV1().Deployments(w.Namespace).Get(ctx, w.Name, metav1.GetOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return nil, err
+	}
+	if kerrors.IsNotFound(err) {
+		_, err = cs.AppsV1().Deployments(w.Namespace).Create(ctx, s.deployment(w, image), metav1.CreateOptions{})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		replicas := int32(0)
+		deploy.Spec.Replicas = &replicas
+		deploy.Spec.Template.Spec.Containers[0].Image = image
+		_, err := cs.AppsV1().Deployments(w.Namespace).Update(ctx, deploy, metav1.UpdateOptions{})
+		if err != nil {
+			return nil, err
+		}
+	}
+	selector := fmt.Sprintf("app=%s", w.Name)
+	err = waitForPodsDeleted(ctx, cs, w.Namespace, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("scaling out deployment")
+	scale := &autoscalingv1.Scale{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      w.Name,
+			Namespace: w.Namespace,
+		},
+		Spec: autoscalingv1.ScaleSpec{
+			Replicas: s.Replicas,
+		},
+	}
+	_, err = cs.AppsV1().Deployments(w.Namespace).UpdateScale(ctx, w.Name, scale, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("waiting for rollout completion")
+	err = waitForCurrent(ctx, dc, deploymentGVR, w.Namespace, w.Name, 30*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	// Replicas sharing a node with a replica that already pulled the image will not pull it again.
+	return collectSamples(ctx, cs, w.Namespace, selector, true)
+}
+
+func (DeploymentScenario) Cleanup(ctx context.Context, cs kubernetes.Interface, w Workload) error {
+	return cs.AppsV1().Deployments(w.Namespace).Delete(ctx, w.Name, metav1.DeleteOptions{})
+}
+
+func (s DeploymentScenario) deployment(w Workload, image string) *appsv1.Deployment {
+	name := w.Name
+	replicas := int32(0)
+	podSpec := corev1.PodSpec{
+		ImagePullSecrets: w.ImagePullSecrets,
+		Containers:       []corev1.Container{benchmarkContainer(image)},
+	}
+	if s.MaxSkew > 0 {
+		podSpec.TopologySpreadConstraints = []corev1.TopologySpreadConstraint{
+			{
+				MaxSkew:           s.MaxSkew,
+				TopologyKey:       s.TopologyKey,
+				WhenUnsatisfiable: corev1.DoNotSchedule,
+				LabelSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"app": name},
+				},
+			},
+		}
+	}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: w.labels(name),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": name},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: w.labels(name),
+				},
+				Spec: podSpec,
+			},
+		},
+	}
+}
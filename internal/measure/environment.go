@@ -0,0 +1,82 @@
+package measure
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// cniDaemonSets maps the DaemonSet name prefixes of common CNI plugins to the plugin name.
+// Canal is listed before Calico and Flannel as it bundles both.
+var cniDaemonSets = []struct {
+	Prefix string
+	Name   string
+}{
+	{Prefix: "canal", Name: "canal"},
+	{Prefix: "cilium", Name: "cilium"},
+	{Prefix: "calico-node", Name: "calico"},
+	{Prefix: "kube-flannel", Name: "flannel"},
+	{Prefix: "weave-net", Name: "weave"},
+	{Prefix: "aws-node", Name: "aws-vpc-cni"},
+	{Prefix: "azure-cns", Name: "azure-cni"},
+	{Prefix: "kindnet", Name: "kindnet"},
+	{Prefix: "antrea-agent", Name: "antrea"},
+	{Prefix: "kube-router", Name: "kube-router"},
+}
+
+func newNode(node corev1.Node) Node {
+	info := node.Status.NodeInfo
+	return Node{
+		Name:                    node.Name,
+		InstanceType:            node.Labels[corev1.LabelInstanceTypeStable],
+		Architecture:            info.Architecture,
+		OperatingSystem:         info.OperatingSystem,
+		OSImage:                 info.OSImage,
+		KernelVersion:           info.KernelVersion,
+		ContainerRuntimeVersion: info.ContainerRuntimeVersion,
+		KubeletVersion:          info.KubeletVersion,
+		Region:                  node.Labels[corev1.LabelTopologyRegion],
+		Zone:                    node.Labels[corev1.LabelTopologyZone],
+		Memory:                  node.Status.Capacity.Memory().Value(),
+		CPU:                     node.Status.Capacity.Cpu().Value(),
+	}
+}
+
+// clusterInfo returns cluster wide facts which are not specific to the nodes running the benchmark.
+func clusterInfo(ctx context.Context, cs kubernetes.Interface) (Cluster, error) {
+	nodeList, err := cs.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return Cluster{}, err
+	}
+	// CNI plugins are often installed in their own namespace, such as calico-system or kube-flannel.
+	dsList, err := cs.AppsV1().DaemonSets(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return Cluster{}, err
+	}
+	dsNames := []string{}
+	for _, ds := range dsList.Items {
+		dsNames = append(dsNames, ds.Name)
+	}
+	return Cluster{
+		CNI:       detectCNI(dsNames),
+		NodeCount: len(nodeList.Items),
+	}, nil
+}
+
+// detectCNI returns the name of the CNI plugin based on the DaemonSets running in the cluster.
+// An empty string is returned when the plugin is not known.
+func detectCNI(dsNames []string) string {
+	for _, cni := range cniDaemonSets {
+		found := slices.ContainsFunc(dsNames, func(name string) bool {
+			return strings.HasPrefix(name, cni.Prefix)
+		})
+		if found {
+			return cni.Name
+		}
+	}
+	return ""
+}
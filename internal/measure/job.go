@@ -0,0 +1,115 @@
+package measure
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// JobScenario runs a burst of short lived Job pods using the same image, as is common in batch and CI clusters.
+type JobScenario struct {
+	// Parallelism is the amount of pods running at the same time.
+	Parallelism int32
+	// Completions is the total amount of pods that have to complete.
+	Completions int32
+}
+
+func (JobScenario) Name() string {
+	return "job"
+}
+
+func (s JobScenario) Measure(ctx context.Context, cs kubernetes.Interface, _ dynamic.Interface, w Workload, image string) ([]Sample, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("image", image, "parallelism", s.Parallelism, "completions", s.Completions)
+	log.Info("measuring pull performance")
+
+	// The pod template of a Job is immutable so any previous Job has to be removed first.
+	err := s.Cleanup(ctx, cs, w)
+	if err != nil && !kerrors.IsNotFound(err) {
+		return nil, err
+	}
+	selector := fmt.Sprintf("app=%s", w.Name)
+	err = waitForPodsDeleted(ctx, cs, w.Namespace, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = cs.BatchV1().Jobs(w.Namespace).Create(ctx, s.job(w, image), metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("waiting for job completion")
+	err = waitForJobComplete(ctx, cs, w.Namespace, w.Name, 30*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	return collectSamples(ctx, cs, w.Namespace, selector, false)
+}
+
+// waitForJobComplete waits for all pods of the Job to complete. Kstatus reports a Job as current as soon as it has started,
+// so the conditions are checked instead.
+func waitForJobComplete(ctx context.Context, cs kubernetes.Interface, namespace, name string, timeout time.Duration) error {
+	log := logr.FromContextOrDiscard(ctx)
+	return wait.PollUntilContextTimeout(ctx, 1*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		job, err := cs.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, cond := range job.Status.Conditions {
+			if cond.Status != corev1.ConditionTrue {
+				continue
+			}
+			switch cond.Type {
+			case batchv1.JobFailed:
+				return false, fmt.Errorf("job %s failed: %s", name, cond.Message)
+			case batchv1.JobComplete:
+				return true, nil
+			}
+		}
+		log.Info("waiting for job completion", "name", name, "succeeded", job.Status.Succeeded)
+		return false, nil
+	})
+}
+
+func (JobScenario) Cleanup(ctx context.Context, cs kubernetes.Interface, w Workload) error {
+	propagation := metav1.DeletePropagationBackground
+	return cs.BatchV1().Jobs(w.Namespace).Delete(ctx, w.Name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+}
+
+func (s JobScenario) job(w Workload, image string) *batchv1.Job {
+	backoffLimit := int32(0)
+	container := benchmarkContainer(image)
+	// The benchmark images are based on the pause image, which exits directly when printing its version.
+	container.Command = []string{"/pause", "-v"}
+	container.Stdin = false
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   w.Name,
+			Labels: w.labels(w.Name),
+		},
+		Spec: batchv1.JobSpec{
+			Parallelism:  &s.Parallelism,
+			Completions:  &s.Completions,
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: w.labels(w.Name),
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy:    corev1.RestartPolicyNever,
+					ImagePullSecrets: w.ImagePullSecrets,
+					Containers:       []corev1.Container{container},
+				},
+			},
+		},
+	}
+}
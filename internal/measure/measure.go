@@ -13,18 +13,16 @@ import (
 
 	"github.com/c2h5oh/datasize"
 	"github.com/go-logr/logr"
-	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/util/intstr"
-	"k8s.io/apimachinery/pkg/util/wait"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
-	"sigs.k8s.io/cli-utils/pkg/kstatus/status"
+
+	"github.com/spegel-org/benchmark/internal/registry"
 )
 
 type Suite struct {
@@ -33,33 +31,108 @@ type Suite struct {
 	Timestamp         time.Time            `json:"timestamp"`
 	Benchmarks        map[string]Benchmark `json:"benchmarks"`
 	Nodes             []Node               `json:"nodes"`
+	Cluster           Cluster              `json:"cluster"`
+	// Spegel is nil for suites recorded before Spegel detection was added.
+	Spegel *Spegel `json:"spegel,omitempty"`
+}
+
+// Cluster describes the cluster the suite was run in.
+type Cluster struct {
+	CNI       string `json:"cni,omitempty"`
+	NodeCount int    `json:"nodeCount"`
 }
 
 type Node struct {
-	Name         string `json:"name"`
-	InstanceType string `json:"instanceType"`
-	Memory       int64  `json:"memory"`
-	CPU          int64  `json:"cpu"`
+	Name                    string `json:"name"`
+	InstanceType            string `json:"instanceType"`
+	Architecture            string `json:"architecture,omitempty"`
+	OperatingSystem         string `json:"operatingSystem,omitempty"`
+	OSImage                 string `json:"osImage,omitempty"`
+	KernelVersion           string `json:"kernelVersion,omitempty"`
+	ContainerRuntimeVersion string `json:"containerRuntimeVersion,omitempty"`
+	KubeletVersion          string `json:"kubeletVersion,omitempty"`
+	Region                  string `json:"region,omitempty"`
+	Zone                    string `json:"zone,omitempty"`
+	Memory                  int64  `json:"memory"`
+	CPU                     int64  `json:"cpu"`
 }
 
 type Benchmark struct {
-	Create Measurement `json:"create"`
-	Update Measurement `json:"update"`
+	Scenario string        `json:"scenario,omitempty"`
+	Cleanup  []NodeCleanup `json:"cleanup,omitempty"`
+	Create   Measurement   `json:"create"`
+	Update   Measurement   `json:"update"`
 }
 
 type Measurement struct {
-	Image   string   `json:"image"`
-	Samples []Sample `json:"samples"`
+	// Metrics is the change of the Spegel metrics during the measurement, keyed by metric name and labels.
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+	// NodeMetrics is the change of the cAdvisor counters of the root cgroup summed across nodes, keyed by metric name and labels.
+	NodeMetrics map[string]float64 `json:"nodeMetrics,omitempty"`
+	// Upstream are the requests served by the built-in upstream registry during the measurement.
+	Upstream *registry.Stats `json:"upstream,omitempty"`
+	// Chaos are the faults injected during the measurement.
+	Chaos []ChaosEvent `json:"chaos,omitempty"`
+	Image string       `json:"image"`
+	// MetricsError is set when the metrics of some pods or nodes could not be scraped, in which case the metrics are incomplete.
+	MetricsError string   `json:"metricsError,omitempty"`
+	Samples      []Sample `json:"samples"`
 }
 
 type Sample struct {
+	Node     string        `json:"node,omitempty"`
 	Start    time.Time     `json:"start"`
 	Stop     time.Time     `json:"stop"`
 	Duration time.Duration `json:"duration"`
 }
 
-func RunSuite(ctx context.Context, kubeconfigPath, namespace, outputDir, suiteName string) error {
-	cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+// Options configures how benchmarks are run against the cluster.
+type Options struct {
+	ImageCleaner ImageCleaner
+	// GarbageCollector is optional and triggers containerd garbage collection after images are removed.
+	GarbageCollector *GarbageCollector
+	KubeconfigPath   string
+	Namespace        string
+	// ImagePullSecret is the name of an existing pull secret, it cannot be combined with the docker config.
+	ImagePullSecret string
+	// DockerConfigPath is optional and creates the pull secret from a local docker config.
+	DockerConfigPath string
+	// RegistryStatsURL is optional and records the requests served by the built-in upstream registry.
+	RegistryStatsURL string
+	// SpegelSelector is the label selector used to find the Spegel DaemonSet and pods in any namespace.
+	SpegelSelector string
+	// Chaos are optional faults injected while the update image is measured.
+	Chaos []ChaosStep
+	// NodeMetrics scrapes the cAdvisor metrics of all nodes in addition to the Spegel metrics.
+	NodeMetrics bool
+}
+
+var (
+	suiteLayerCounts = []int{1, 4}
+	suiteImageSizes  = []datasize.ByteSize{datasize.MB * 10, datasize.MB * 100, datasize.GB}
+)
+
+func suiteImage(version string, imageSize datasize.ByteSize, layerCount int) string {
+	return fmt.Sprintf("ghcr.io/spegel-org/benchmark:%s-%s-%d", version, imageSize.String(), layerCount)
+}
+
+// suiteImages returns all images pulled by a suite.
+func suiteImages() []string {
+	images := []string{}
+	for _, layerCount := range suiteLayerCounts {
+		for _, imageSize := range suiteImageSizes {
+			images = append(images, suiteImage("v1", imageSize, layerCount), suiteImage("v2", imageSize, layerCount))
+		}
+	}
+	return images
+}
+
+func RunSuite(ctx context.Context, opts Options, scenarios []Scenario, outputDir, suiteName string) error {
+	if len(scenarios) == 0 {
+		return errors.New("at least one scenario is required")
+	}
+
+	cfg, err := clientcmd.BuildConfigFromFlags("", opts.KubeconfigPath)
 	if err != nil {
 		return err
 	}
@@ -75,53 +148,53 @@ func RunSuite(ctx context.Context, kubeconfigPath, namespace, outputDir, suiteNa
 	}
 
 	nodes := []Node{}
-	nodeList, err := cs.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	benchNodes, err := benchmarkNodes(ctx, cs)
 	if err != nil {
 		return err
 	}
-	for _, node := range nodeList.Items {
-		if len(node.Spec.Taints) > 0 {
-			logr.FromContextOrDiscard(ctx).Info("skipping node with taint", "name", node.Name)
-			continue
-		}
-		n := Node{
-			Name:         node.Name,
-			InstanceType: node.Labels["node.kubernetes.io/instance-type"],
-			Memory:       node.Status.Capacity.Memory().Value(),
-			CPU:          node.Status.Capacity.Cpu().Value(),
-		}
-		nodes = append(nodes, n)
+	for _, node := range benchNodes {
+		nodes = append(nodes, newNode(node))
+	}
+	cluster, err := clusterInfo(ctx, cs)
+	if err != nil {
+		return err
+	}
+	spegel, err := detectSpegel(ctx, cs, opts.SpegelSelector)
+	if err != nil {
+		return err
 	}
+	logr.FromContextOrDiscard(ctx).Info("detected spegel", "detected", spegel.Detected, "version", spegel.Version)
 
+	runID := newRunID()
+	logr.FromContextOrDiscard(ctx).Info("starting suite", "runID", runID)
 	suite := Suite{
 		Name:              suiteName,
 		Timestamp:         time.Now(),
 		KubernetesVersion: versionInfo.GitVersion,
 		Nodes:             nodes,
+		Cluster:           cluster,
+		Spegel:            &spegel,
 		Benchmarks:        map[string]Benchmark{},
 	}
-	registry := "ghcr.io"
-	repository := "spegel-org/benchmark"
-	layerCounts := []int{1, 4}
-	imageSizes := []datasize.ByteSize{datasize.MB * 10, datasize.MB * 100, datasize.GB}
-	for _, layerCount := range layerCounts {
-		for _, imageSize := range imageSizes {
-			log := logr.FromContextOrDiscard(ctx).WithValues("layers", layerCount, "size", imageSize.String())
-			imgs := []string{
-				fmt.Sprintf("%s/%s:v1-%s-%d", registry, repository, imageSize.String(), layerCount),
-				fmt.Sprintf("%s/%s:v2-%s-%d", registry, repository, imageSize.String(), layerCount),
-			}
-			log.Info("benchmark started")
-			benchmark, err := benchmark(ctx, kubeconfigPath, namespace, imgs[0], imgs[1])
-			if err != nil {
-				return err
+	for _, scenario := range scenarios {
+		for _, layerCount := range suiteLayerCounts {
+			for _, imageSize := range suiteImageSizes {
+				log := logr.FromContextOrDiscard(ctx).WithValues("scenario", scenario.Name(), "layers", layerCount, "size", imageSize.String())
+				imgs := []string{
+					suiteImage("v1", imageSize, layerCount),
+					suiteImage("v2", imageSize, layerCount),
+				}
+				log.Info("benchmark started")
+				benchmark, err := benchmark(ctx, opts, runID, scenario, imgs[0], imgs[1])
+				if err != nil {
+					return err
+				}
+				suite.Benchmarks[benchmarkKey(scenario, imageSize, layerCount)] = benchmark
+				log.Info("benchmark completed")
+
+				// Some delay between tests.
+				time.Sleep(3 * time.Second)
 			}
-			k := fmt.Sprintf("%s-%d", imageSize.String(), layerCount)
-			suite.Benchmarks[k] = benchmark
-			log.Info("benchmark completed")
-
-			// Some delay between tests.
-			time.Sleep(3 * time.Second)
 		}
 	}
 
@@ -142,8 +215,14 @@ func RunSuite(ctx context.Context, kubeconfigPath, namespace, outputDir, suiteNa
 	return nil
 }
 
-func RunMeasure(ctx context.Context, kubeconfigPath, namespace, outputDir string, images []string) error {
-	benchmark, err := benchmark(ctx, kubeconfigPath, namespace, images[0], images[1])
+// RunMeasure measures the first image being created and then updated to the second image.
+func RunMeasure(ctx context.Context, opts Options, scenario Scenario, outputDir string, images []string) error {
+	if len(images) != 2 {
+		return fmt.Errorf("expected two images to measure create and update but got %d", len(images))
+	}
+	runID := newRunID()
+	logr.FromContextOrDiscard(ctx).Info("starting measurement", "runID", runID)
+	benchmark, err := benchmark(ctx, opts, runID, scenario, images[0], images[1])
 	if err != nil {
 		return err
 	}
@@ -165,10 +244,21 @@ func RunMeasure(ctx context.Context, kubeconfigPath, namespace, outputDir string
 	return nil
 }
 
-func benchmark(ctx context.Context, kubeconfigPath, namespace, createImage, updateImage string) (Benchmark, error) {
+// benchmarkKey returns the suite key for a benchmark. DaemonSet benchmarks keep the
+// plain key so that suites remain comparable with results from earlier versions.
+func benchmarkKey(scenario Scenario, imageSize datasize.ByteSize, layerCount int) string {
+	k := fmt.Sprintf("%s-%d", imageSize.String(), layerCount)
+	if _, ok := scenario.(DaemonSetScenario); ok {
+		return k
+	}
+	return fmt.Sprintf("%s-%s", scenario.Name(), k)
+}
+
+func benchmark(ctx context.Context, opts Options, runID string, scenario Scenario, createImage, updateImage string) (Benchmark, error) {
 	log := logr.FromContextOrDiscard(ctx)
+	namespace := opts.Namespace
 
-	cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	cfg, err := clientcmd.BuildConfigFromFlags("", opts.KubeconfigPath)
 	if err != nil {
 		return Benchmark{}, err
 	}
@@ -182,65 +272,80 @@ func benchmark(ctx context.Context, kubeconfigPath, namespace, createImage, upda
 	}
 
 	benchmark := Benchmark{
-		Create: Measurement{
-			Image: createImage,
-		},
-		Update: Measurement{
-			Image: updateImage,
-		},
+		Scenario: scenario.Name(),
 	}
 	images := []string{
-		benchmark.Create.Image,
-		benchmark.Update.Image,
+		createImage,
+		updateImage,
 	}
 
-	runName := fmt.Sprintf("spegel-benchmark-%d", time.Now().Unix())
+	w := Workload{
+		Cleaner:          opts.ImageCleaner,
+		GarbageCollector: opts.GarbageCollector,
+		Namespace:        namespace,
+		Name:             fmt.Sprintf("spegel-benchmark-%d-%s", time.Now().Unix(), utilrand.String(5)),
+		RunID:            runID,
+	}
 
-	// Create namespace for benchmark.
-	_, err = cs.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
-	if err != nil && !kerrors.IsNotFound(err) {
+	err = ensureNamespace(ctx, cs, namespace)
+	if err != nil {
 		return Benchmark{}, err
 	}
-	if kerrors.IsNotFound(err) {
-		ns := corev1.Namespace{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: namespace,
-			},
-		}
-		_, err := cs.CoreV1().Namespaces().Create(ctx, &ns, metav1.CreateOptions{})
-		if err != nil {
-			return Benchmark{}, err
-		}
-	}
 
-	err = clearImages(ctx, cs, dc, namespace, images)
+	staleIDs, err := staleRunIDs(ctx, cs, namespace, runID)
 	if err != nil {
 		return Benchmark{}, err
 	}
+	if len(staleIDs) > 0 {
+		log.Info("found workloads from other runs which may affect the measurement, remove them with the cleanup command if the runs are not in progress", "runIDs", staleIDs)
+	}
 
-	// Run image pull measurements.
 	defer func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
 		defer cancel()
-		err := cs.AppsV1().DaemonSets(namespace).Delete(ctx, runName, metav1.DeleteOptions{})
+		err := ignoreNotFound(scenario.Cleanup(ctx, cs, w))
 		if err != nil {
-			log.Error(err, "could not delete measure daemonset")
+			log.Error(err, "could not clean up benchmark workload", "scenario", scenario.Name())
+		}
+		if opts.DockerConfigPath == "" || len(w.ImagePullSecrets) == 0 {
+			return
+		}
+		err = ignoreNotFound(cs.CoreV1().Secrets(namespace).Delete(ctx, w.ImagePullSecrets[0].Name, metav1.DeleteOptions{}))
+		if err != nil {
+			log.Error(err, "could not delete image pull secret")
 		}
 	}()
 
-	samples, err := measureImagePull(ctx, cs, dc, namespace, runName, createImage)
+	pullSecret, err := ensurePullSecret(ctx, cs, w, opts.ImagePullSecret, opts.DockerConfigPath)
+	if err != nil {
+		return Benchmark{}, err
+	}
+	if pullSecret != "" {
+		w.ImagePullSecrets = []corev1.LocalObjectReference{{Name: pullSecret}}
+	}
+	w.Keychain, err = newRegistryKeychain(ctx, cs, namespace, pullSecret, opts.DockerConfigPath)
 	if err != nil {
 		return Benchmark{}, err
 	}
-	benchmark.Create.Samples = samples
 
-	samples, err = measureImagePull(ctx, cs, dc, namespace, runName, updateImage)
+	cleanup, err := removeImages(ctx, cs, dc, w, images, nil)
 	if err != nil {
 		return Benchmark{}, err
 	}
-	benchmark.Update.Samples = samples
+	benchmark.Cleanup = cleanup
+
+	// Run image pull measurements.
 
-	err = clearImages(ctx, cs, dc, namespace, images)
+	benchmark.Create, err = measureImage(ctx, cs, dc, opts, scenario, w, createImage, nil)
+	if err != nil {
+		return Benchmark{}, err
+	}
+	benchmark.Update, err = measureImage(ctx, cs, dc, opts, scenario, w, updateImage, opts.Chaos)
+	if err != nil {
+		return Benchmark{}, err
+	}
+
+	_, err = removeImages(ctx, cs, dc, w, images, nil)
 	if err != nil {
 		return Benchmark{}, err
 	}
@@ -248,241 +353,140 @@ func benchmark(ctx context.Context, kubeconfigPath, namespace, createImage, upda
 	return benchmark, nil
 }
 
-func clearImages(ctx context.Context, cs kubernetes.Interface, dc dynamic.Interface, namespace string, images []string) error {
+// measureImage runs the scenario for the image, injecting the faults of the chaos steps, and records the change in Spegel metrics while it runs.
+func measureImage(ctx context.Context, cs kubernetes.Interface, dc dynamic.Interface, opts Options, scenario Scenario, w Workload, image string, chaosSteps []ChaosStep) (Measurement, error) {
 	log := logr.FromContextOrDiscard(ctx)
-	log.Info("clearing images")
-
-	removeImages := fmt.Sprintf("crictl rmi %s || true", strings.Join(images, " "))
-	script := fmt.Sprintf(`#!/bin/sh
-chroot /host /bin/bash -c '%s'
-echo "Cleanup run"
-sleep infinity &
-wait $!`, removeImages)
-	cm := &corev1.ConfigMap{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: "spegel-clear-image",
-		},
-		Data: map[string]string{
-			"run.sh": script,
-		},
+	before, beforeErr := scrapeMetrics(ctx, cs, opts.SpegelSelector)
+	if beforeErr != nil {
+		log.Error(beforeErr, "could not scrape metrics before measurement", "image", image)
+	}
+	var nodeBefore map[string]map[string]float64
+	var nodeBeforeErr error
+	if opts.NodeMetrics {
+		nodeBefore, nodeBeforeErr = scrapeNodeMetrics(ctx, cs)
+		if nodeBeforeErr != nil {
+			log.Error(nodeBeforeErr, "could not scrape node metrics before measurement", "image", image)
+		}
 	}
-	_, err := cs.CoreV1().ConfigMaps(namespace).Create(ctx, cm, metav1.CreateOptions{})
+	upstreamBefore, err := fetchRegistryStats(ctx, opts.RegistryStatsURL)
 	if err != nil {
-		return err
+		return Measurement{}, err
 	}
-
-	filePerm := int32(0o755)
-	ds := &appsv1.DaemonSet{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: "spegel-clear-image",
-		},
-		Spec: appsv1.DaemonSetSpec{
-			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{"app": "spegel-clear-image"},
-			},
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
-						"app": "spegel-clear-image",
-					},
-				},
-				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{
-						{
-							Name:            "clear",
-							Image:           "docker.io/library/alpine:3.21.3@sha256:a8560b36e8b8210634f77d9f7f9efd7ffa463e380b75e2e74aff4511df3ef88c",
-							ImagePullPolicy: "IfNotPresent",
-							Command:         []string{"/scripts/run.sh"},
-							Stdin:           true,
-							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      "scripts",
-									MountPath: "/scripts/run.sh",
-									SubPath:   "run.sh",
-								},
-								{
-									Name:      "host-root",
-									MountPath: "/host",
-								},
-							},
-						},
-					},
-					Volumes: []corev1.Volume{
-						{
-							Name: "scripts",
-							VolumeSource: corev1.VolumeSource{
-								ConfigMap: &corev1.ConfigMapVolumeSource{
-									LocalObjectReference: corev1.LocalObjectReference{
-										Name: "spegel-clear-image",
-									},
-									DefaultMode: &filePerm,
-								},
-							},
-						},
-						{
-							Name: "host-root",
-							VolumeSource: corev1.VolumeSource{
-								HostPath: &corev1.HostPathVolumeSource{
-									Path: "/",
-								},
-							},
-						},
-					},
-				},
-			},
-		},
+	chaos := startChaos(ctx, cs, chaosSteps)
+	samples, err := scenario.Measure(ctx, cs, dc, w, image)
+	chaosEvents, chaosErr := chaos.stop(ctx)
+	err = errors.Join(err, chaosErr)
+	if err != nil {
+		return Measurement{}, err
+	}
+	after, afterErr := scrapeMetrics(ctx, cs, opts.SpegelSelector)
+	if afterErr != nil {
+		log.Error(afterErr, "could not scrape metrics after measurement", "image", image)
+	}
+	var nodeAfter map[string]map[string]float64
+	var nodeAfterErr error
+	if opts.NodeMetrics {
+		nodeAfter, nodeAfterErr = scrapeNodeMetrics(ctx, cs)
+		if nodeAfterErr != nil {
+			log.Error(nodeAfterErr, "could not scrape node metrics after measurement", "image", image)
+		}
 	}
-	_, err = cs.AppsV1().DaemonSets(namespace).Create(ctx, ds, metav1.CreateOptions{})
+	upstreamAfter, err := fetchRegistryStats(ctx, opts.RegistryStatsURL)
 	if err != nil {
-		return err
+		return Measurement{}, err
 	}
+	m := Measurement{
+		Image:       image,
+		Samples:     samples,
+		Metrics:     metricsDelta(before, after),
+		NodeMetrics: metricsDelta(nodeBefore, nodeAfter),
+		Chaos:       chaosEvents,
+	}
+	metricsErr := errors.Join(beforeErr, afterErr, nodeBeforeErr, nodeAfterErr)
+	if metricsErr != nil {
+		m.MetricsError = metricsErr.Error()
+	}
+	if upstreamAfter != nil {
+		upstream := upstreamAfter.Sub(*upstreamBefore)
+		m.Upstream = &upstream
+	}
+	return m, nil
+}
 
-	defer func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		err := cs.AppsV1().DaemonSets(namespace).Delete(ctx, ds.Name, metav1.DeleteOptions{})
-		if err != nil {
-			log.Error(err, "could not delete image cleanup daemonset")
-		}
-		err = cs.CoreV1().ConfigMaps(namespace).Delete(ctx, cm.Name, metav1.DeleteOptions{})
-		if err != nil {
-			log.Error(err, "could not delete image cleanup config map")
-		}
-	}()
-
-	err = wait.PollUntilContextTimeout(ctx, 1*time.Second, 10*time.Minute, true, func(ctx context.Context) (done bool, err error) {
-		gvr := schema.GroupVersionResource{
-			Group:    "apps",
-			Version:  "v1",
-			Resource: "daemonsets",
-		}
-		u, err := dc.Resource(gvr).Namespace(namespace).Get(ctx, ds.Name, metav1.GetOptions{})
-		if err != nil {
-			return false, err
-		}
-		res, err := status.Compute(u)
-		if err != nil {
-			return false, err
-		}
-		if res.Status != status.CurrentStatus {
-			return false, nil
-		}
-		return true, nil
-	})
+// fetchRegistryStats returns the stats of the built-in registry, or nil if no stats URL is configured.
+func fetchRegistryStats(ctx context.Context, url string) (*registry.Stats, error) {
+	if url == "" {
+		return nil, nil
+	}
+	stats, err := registry.FetchStats(ctx, url)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("could not fetch upstream registry stats: %w", err)
 	}
-	return nil
+	return &stats, nil
 }
 
-func measureImagePull(ctx context.Context, cs kubernetes.Interface, dc dynamic.Interface, namespace, name, image string) ([]Sample, error) {
-	log := logr.FromContextOrDiscard(ctx).WithValues("image", image)
-	log.Info("measuring pull performance")
-	ds, err := cs.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+// ensureNamespace creates the namespace for the benchmark if it does not exist.
+func ensureNamespace(ctx context.Context, cs kubernetes.Interface, namespace string) error {
+	_, err := cs.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
 	if err != nil && !kerrors.IsNotFound(err) {
-		return nil, err
+		return err
 	}
 	if kerrors.IsNotFound(err) {
-		maxUnavailable := intstr.FromString("20%")
-		ds := &appsv1.DaemonSet{
+		ns := corev1.Namespace{
 			ObjectMeta: metav1.ObjectMeta{
-				Name: name,
-			},
-			Spec: appsv1.DaemonSetSpec{
-				Selector: &metav1.LabelSelector{
-					MatchLabels: map[string]string{"app": name},
-				},
-				UpdateStrategy: appsv1.DaemonSetUpdateStrategy{
-					RollingUpdate: &appsv1.RollingUpdateDaemonSet{
-						MaxUnavailable: &maxUnavailable,
-					},
-				},
-				Template: corev1.PodTemplateSpec{
-					ObjectMeta: metav1.ObjectMeta{
-						Labels: map[string]string{
-							"app": name,
-						},
-					},
-					Spec: corev1.PodSpec{
-						Containers: []corev1.Container{
-							{
-								Name:            "benchmark",
-								Image:           image,
-								ImagePullPolicy: "IfNotPresent",
-								// Keep container running
-								Stdin: true,
-							},
-						},
-					},
+				Name: namespace,
+				Labels: map[string]string{
+					managedByLabel: managedByValue,
 				},
 			},
 		}
-		_, err = cs.AppsV1().DaemonSets(namespace).Create(ctx, ds, metav1.CreateOptions{})
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		ds.Spec.Template.Spec.Containers[0].Image = image
-		_, err := cs.AppsV1().DaemonSets(namespace).Update(ctx, ds, metav1.UpdateOptions{})
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	log.Info("waiting for rollout completion")
-	err = wait.PollUntilContextTimeout(ctx, 1*time.Second, 30*time.Minute, true, func(ctx context.Context) (done bool, err error) {
-		gvr := schema.GroupVersionResource{
-			Group:    "apps",
-			Version:  "v1",
-			Resource: "daemonsets",
-		}
-		u, err := dc.Resource(gvr).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
-		if err != nil {
-			return false, err
-		}
-		res, err := status.Compute(u)
+		_, err := cs.CoreV1().Namespaces().Create(ctx, &ns, metav1.CreateOptions{})
 		if err != nil {
-			return false, err
-		}
-		if res.Status != status.CurrentStatus {
-			log.Info("waiting for rollout", "message", res.Message)
-			return false, nil
+			return err
 		}
-		return true, nil
-	})
-	if err != nil {
-		return nil, err
 	}
+	return nil
+}
 
-	log.Info("collecting image pull durations")
-	podList, err := cs.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: fmt.Sprintf("app=%s", name)})
+// benchmarkNodes returns the nodes that benchmark pods will be scheduled on.
+func benchmarkNodes(ctx context.Context, cs kubernetes.Interface) ([]corev1.Node, error) {
+	nodeList, err := cs.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return nil, err
 	}
-	if len(podList.Items) == 0 {
-		return nil, errors.New("received empty benchmark pod list")
-	}
-	samples := []Sample{}
-	for _, pod := range podList.Items {
-		eventList, err := cs.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{FieldSelector: fmt.Sprintf("involvedObject.name=%s", pod.Name), TypeMeta: metav1.TypeMeta{Kind: "Pod"}})
-		if err != nil {
-			return nil, err
-		}
-		pullingEvent, err := getEvent(eventList.Items, "Pulling")
-		if err != nil {
-			return nil, err
-		}
-		pulledEvent, err := getEvent(eventList.Items, "Pulled")
-		if err != nil {
-			return nil, err
-		}
-		d, err := parsePullMessage(pulledEvent.Message)
-		if err != nil {
-			return nil, err
+	nodes := []corev1.Node{}
+	for _, node := range nodeList.Items {
+		if len(node.Spec.Taints) > 0 {
+			logr.FromContextOrDiscard(ctx).Info("skipping node with taint", "name", node.Name)
+			continue
 		}
-		samples = append(samples, Sample{Start: pullingEvent.FirstTimestamp.Time, Stop: pullingEvent.FirstTimestamp.Add(d), Duration: d})
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// nodeNameAffinity restricts pods to the given nodes. Nil is returned when no node names are given.
+func nodeNameAffinity(nodeNames []string) *corev1.Affinity {
+	if len(nodeNames) == 0 {
+		return nil
+	}
+	return &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{
+						MatchFields: []corev1.NodeSelectorRequirement{
+							{
+								Key:      "metadata.name",
+								Operator: corev1.NodeSelectorOpIn,
+								Values:   nodeNames,
+							},
+						},
+					},
+				},
+			},
+		},
 	}
-	return samples, nil
 }
 
 func getEvent(events []corev1.Event, reason string) (corev1.Event, error) {
@@ -1,10 +1,31 @@
 package measure
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/c2h5oh/datasize"
+	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
 )
 
 func TestParsePullMessage(t *testing.T) {
@@ -15,3 +36,950 @@ func TestParsePullMessage(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, 873420598*time.Nanosecond, d)
 }
+
+func TestBenchmarkKey(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "10MB-1", benchmarkKey(DaemonSetScenario{}, 10*datasize.MB, 1))
+	require.Equal(t, "deployment-1GB-4", benchmarkKey(DeploymentScenario{}, datasize.GB, 4))
+}
+
+func TestIsAlreadyPresent(t *testing.T) {
+	t.Parallel()
+
+	require.True(t, isAlreadyPresent("Container image \"ghcr.io/spegel-org/benchmark:v1-10MB-1\" already present on machine"))
+	require.True(t, isAlreadyPresent("Container image \"ghcr.io/spegel-org/benchmark:v1-10MB-1\" already present on machine and can be accessed by the pod"))
+	require.False(t, isAlreadyPresent("Successfully pulled image \"docker.io/library/nginx:mainline-alpine\" in 873.420598ms (873.428863ms including waiting)"))
+}
+
+func TestCollectSamplesAlreadyPresent(t *testing.T) {
+	t.Parallel()
+
+	cs := fake.NewClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo-1", Namespace: "default", Labels: map[string]string{"app": "foo"}},
+			Spec:       corev1.PodSpec{NodeName: "node-1"},
+		},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: "foo-1.pulled", Namespace: "default"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "foo-1"},
+			Reason:         "Pulled",
+			Message:        "Container image \"ghcr.io/spegel-org/benchmark:v1-10MB-1\" already present on machine",
+		},
+	)
+	_, err := collectSamples(t.Context(), cs, "default", "app=foo", false)
+	require.EqualError(t, err, "image was already present on node node-1 for pod foo-1")
+	_, err = collectSamples(t.Context(), cs, "default", "app=foo", true)
+	require.EqualError(t, err, "no benchmark pod pulled the image")
+}
+
+func TestSelectColdNodes(t *testing.T) {
+	t.Parallel()
+
+	nodeNames := []string{"node-c", "node-a", "node-b"}
+	coldNodes, err := selectColdNodes(nodeNames, nil, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"node-b", "node-c"}, coldNodes)
+	coldNodes, err = selectColdNodes(nodeNames, []string{"node-a"}, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"node-a"}, coldNodes)
+	_, err = selectColdNodes(nodeNames, []string{"node-d"}, 0)
+	require.EqualError(t, err, "cold node node-d is not a schedulable node")
+	_, err = selectColdNodes(nodeNames, nil, 3)
+	require.EqualError(t, err, "cold node count 3 leaves no warm nodes out of 3 nodes")
+	_, err = selectColdNodes(nodeNames, nil, 0)
+	require.EqualError(t, err, "at least one cold node is required")
+}
+
+func TestRunMeasureImages(t *testing.T) {
+	t.Parallel()
+
+	err := RunMeasure(t.Context(), Options{}, DaemonSetScenario{}, t.TempDir(), []string{"ghcr.io/spegel-org/benchmark:v1-10MB-1"})
+	require.EqualError(t, err, "expected two images to measure create and update but got 1")
+}
+
+func TestJob(t *testing.T) {
+	t.Parallel()
+
+	w := Workload{
+		Name:             "foo",
+		RunID:            "123",
+		ImagePullSecrets: []corev1.LocalObjectReference{{Name: "pull-secret"}},
+	}
+	job := JobScenario{Parallelism: 5, Completions: 10}.job(w, "ghcr.io/spegel-org/benchmark:v1-10MB-1")
+	require.Equal(t, int32(5), *job.Spec.Parallelism)
+	require.Equal(t, int32(10), *job.Spec.Completions)
+	require.Equal(t, map[string]string{"app": "foo", managedByLabel: managedByValue, runIDLabel: "123"}, job.Spec.Template.Labels)
+	require.Equal(t, []string{"/pause", "-v"}, job.Spec.Template.Spec.Containers[0].Command)
+	require.Equal(t, w.ImagePullSecrets, job.Spec.Template.Spec.ImagePullSecrets)
+}
+
+func TestDeployment(t *testing.T) {
+	t.Parallel()
+
+	w := Workload{
+		Name:             "foo",
+		RunID:            "123",
+		ImagePullSecrets: []corev1.LocalObjectReference{{Name: "pull-secret"}},
+	}
+	image := "ghcr.io/spegel-org/benchmark:v1-10MB-1"
+	deploy := DeploymentScenario{TopologyKey: "kubernetes.io/hostname", Replicas: 10, MaxSkew: 2}.deployment(w, image)
+	require.Equal(t, int32(0), *deploy.Spec.Replicas)
+	require.Equal(t, map[string]string{"app": "foo"}, deploy.Spec.Selector.MatchLabels)
+	require.Equal(t, map[string]string{"app": "foo", managedByLabel: managedByValue, runIDLabel: "123"}, deploy.Spec.Template.Labels)
+	require.Equal(t, image, deploy.Spec.Template.Spec.Containers[0].Image)
+	require.Equal(t, w.ImagePullSecrets, deploy.Spec.Template.Spec.ImagePullSecrets)
+	expected := []corev1.TopologySpreadConstraint{
+		{
+			MaxSkew:           2,
+			TopologyKey:       "kubernetes.io/hostname",
+			WhenUnsatisfiable: corev1.DoNotSchedule,
+			LabelSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": "foo"}},
+		},
+	}
+	require.Equal(t, expected, deploy.Spec.Template.Spec.TopologySpreadConstraints)
+
+	deploy = DeploymentScenario{TopologyKey: "kubernetes.io/hostname", Replicas: 10}.deployment(w, image)
+	require.Empty(t, deploy.Spec.Template.Spec.TopologySpreadConstraints)
+}
+
+func TestWaitForJobComplete(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		status      batchv1.JobStatus
+		expectedErr string
+	}{
+		{
+			name:   "complete",
+			status: batchv1.JobStatus{Succeeded: 10, Conditions: []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}},
+		},
+		{
+			name:        "failed",
+			status:      batchv1.JobStatus{Conditions: []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "backoff limit exceeded"}}},
+			expectedErr: "job foo failed: backoff limit exceeded",
+		},
+		{
+			name:        "running",
+			status:      batchv1.JobStatus{Active: 10, Succeeded: 5, StartTime: &metav1.Time{Time: time.Now()}},
+			expectedErr: "context deadline exceeded",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "bar"}, Status: tt.status}
+			cs := fake.NewClientset(job)
+			err := waitForJobComplete(t.Context(), cs, "bar", "foo", 100*time.Millisecond)
+			if tt.expectedErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tt.expectedErr)
+		})
+	}
+}
+
+func TestCleanerCommands(t *testing.T) {
+	t.Parallel()
+
+	// The fake crictl reports foo as present, bar as missing and fails for anything else but the version.
+	crictl := `#!/bin/sh
+case "$1 $2" in
+  "inspecti foo") exit 0 ;;
+  "inspecti bar") echo 'no such image "bar" present' >&2; exit 1 ;;
+  "rmi foo") echo "Deleted: foo"; exit 0 ;;
+  "version ") echo "RuntimeName: containerd"; exit 0 ;;
+  *) echo "connection refused" >&2; exit 1 ;;
+esac
+`
+	toolDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(toolDir, "crictl"), []byte(crictl), 0o755)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		path           string
+		command        string
+		expectedOutput string
+		expectedErr    bool
+	}{
+		{
+			name:           "remove present and missing images",
+			path:           toolDir + ":/usr/bin:/bin",
+			command:        CrictlCleaner{}.Command([]string{"foo", "bar"}),
+			expectedOutput: "Deleted: foo\n",
+		},
+		{
+			name:           "inspect error",
+			path:           toolDir + ":/usr/bin:/bin",
+			command:        CrictlCleaner{}.Command([]string{"baz"}),
+			expectedOutput: "connection refused\n",
+			expectedErr:    true,
+		},
+		{
+			name:           "crictl not installed",
+			path:           t.TempDir(),
+			command:        CrictlCleaner{}.Command([]string{"foo"}),
+			expectedOutput: "crictl not found\n",
+			expectedErr:    true,
+		},
+		{
+			name:           "verify",
+			path:           toolDir + ":/usr/bin:/bin",
+			command:        CrictlCleaner{}.VerifyCommand([]string{"foo", "bar"}, nil),
+			expectedOutput: "foo\n",
+		},
+		{
+			name:           "verify crictl not installed",
+			path:           t.TempDir(),
+			command:        CrictlCleaner{}.VerifyCommand([]string{"foo"}, nil),
+			expectedOutput: "crictl not found\n",
+			expectedErr:    true,
+		},
+		{
+			name:           "probe",
+			path:           toolDir + ":/usr/bin:/bin",
+			command:        CrictlCleaner{}.ProbeCommand(),
+			expectedOutput: "RuntimeName: containerd\n",
+		},
+		{
+			name:           "probe crictl not installed",
+			path:           t.TempDir(),
+			command:        CrictlCleaner{}.ProbeCommand(),
+			expectedOutput: "crictl not found\n",
+			expectedErr:    true,
+		},
+		{
+			name:           "probe ctr not installed",
+			path:           t.TempDir(),
+			command:        CtrCleaner{Namespace: "k8s.io"}.ProbeCommand(),
+			expectedOutput: "ctr not found\n",
+			expectedErr:    true,
+		},
+		{
+			name:           "ctr not installed",
+			path:           t.TempDir(),
+			command:        CtrCleaner{Namespace: "k8s.io"}.Command([]string{"foo"}),
+			expectedOutput: "ctr not found\n",
+			expectedErr:    true,
+		},
+		{
+			name:           "verify ctr not installed",
+			path:           t.TempDir(),
+			command:        CtrCleaner{Namespace: "k8s.io"}.VerifyCommand([]string{"foo"}, []string{"sha256:abc"}),
+			expectedOutput: "ctr not found\n",
+			expectedErr:    true,
+		},
+		{
+			name:           "garbage collection ctr not installed",
+			path:           t.TempDir(),
+			command:        GarbageCollector{Namespace: "k8s.io"}.Command(),
+			expectedOutput: "ctr not found\n",
+			expectedErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cmd := exec.CommandContext(t.Context(), "/bin/sh", "-c", tt.command)
+			cmd.Env = []string{"PATH=" + tt.path}
+			b, err := cmd.CombinedOutput()
+			require.Equal(t, tt.expectedOutput, string(b))
+			if tt.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestControlledPods(t *testing.T) {
+	t.Parallel()
+
+	isController := true
+	ds := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: clearImageName, UID: "new"}}
+	pods := []corev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "old", OwnerReferences: []metav1.OwnerReference{{Name: clearImageName, UID: "old", Controller: &isController}}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "new", OwnerReferences: []metav1.OwnerReference{{Name: clearImageName, UID: "new", Controller: &isController}}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "orphan"}},
+	}
+	controlled := controlledPods(pods, ds)
+	require.Len(t, controlled, 1)
+	require.Equal(t, "new", controlled[0].Name)
+}
+
+func TestParseCleanupLog(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		output   string
+		expected NodeCleanup
+	}{
+		{
+			name:     "success",
+			output:   "Deleted: ghcr.io/spegel-org/benchmark:v1-10MB-1\nspegel-clear-image: success\n",
+			expected: NodeCleanup{Node: "node", Success: true, Message: "Deleted: ghcr.io/spegel-org/benchmark:v1-10MB-1"},
+		},
+		{
+			name:     "failure",
+			output:   "chroot: failed to run command '/bin/sh': No such file or directory\nspegel-clear-image: failure\n",
+			expected: NodeCleanup{Node: "node", Success: false, Message: "chroot: failed to run command '/bin/sh': No such file or directory"},
+		},
+		{
+			name:     "remaining",
+			output:   "spegel-clear-image: success\nspegel-clear-image: remaining ghcr.io/spegel-org/benchmark:v1-10MB-1\nspegel-clear-image: remaining sha256:4ab5f0b3b5b8ce0b1f3d2d6c9a1b1f6e9d2c4a7f3b8e6d5c4b3a291807f6e5d4\n",
+			expected: NodeCleanup{Node: "node", Success: true, Remaining: []string{"ghcr.io/spegel-org/benchmark:v1-10MB-1", "sha256:4ab5f0b3b5b8ce0b1f3d2d6c9a1b1f6e9d2c4a7f3b8e6d5c4b3a291807f6e5d4"}},
+		},
+		{
+			name:     "reclaimed",
+			output:   "spegel-clear-image: reclaimed 10485760\nspegel-clear-image: success\n",
+			expected: NodeCleanup{Node: "node", Success: true, ReclaimedBytes: 10485760},
+		},
+		{
+			name:     "incomplete",
+			output:   "",
+			expected: NodeCleanup{Node: "node", Success: false, Message: "image cleanup did not complete"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			result := parseCleanupLog("node", tt.output)
+			require.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestNodeImagesRemaining(t *testing.T) {
+	t.Parallel()
+
+	nodes := []corev1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+			Status: corev1.NodeStatus{
+				Images: []corev1.ContainerImage{
+					{Names: []string{"ghcr.io/spegel-org/benchmark@sha256:3a6c0a7b8f2c1e6d5b4a39281706f5e4d3c2b1a09f8e7d6c5b4a3928170f6e5d", "ghcr.io/spegel-org/benchmark:v1-10MB-1"}},
+					{Names: []string{"registry.k8s.io/pause:3.10"}},
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-b"},
+			Status: corev1.NodeStatus{
+				Images: []corev1.ContainerImage{
+					{Names: []string{"ghcr.io/spegel-org/benchmark:v1-10MB-1"}},
+				},
+			},
+		},
+	}
+	images := []string{"ghcr.io/spegel-org/benchmark:v1-10MB-1", "ghcr.io/spegel-org/benchmark:v2-10MB-1"}
+	remaining := nodeImagesRemaining(nodes, images, nil)
+	require.Equal(t, []string{"ghcr.io/spegel-org/benchmark:v1-10MB-1 on node-a", "ghcr.io/spegel-org/benchmark:v1-10MB-1 on node-b"}, remaining)
+	remaining = nodeImagesRemaining(nodes, images, []string{"node-b"})
+	require.Equal(t, []string{"ghcr.io/spegel-org/benchmark:v1-10MB-1 on node-b"}, remaining)
+	remaining = nodeImagesRemaining(nodes, []string{"ghcr.io/spegel-org/benchmark:v2-10MB-1"}, nil)
+	require.Empty(t, remaining)
+}
+
+func TestEnsurePullSecret(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	cs := fake.NewClientset()
+	w := Workload{Namespace: "default", RunID: "123"}
+
+	secretName, err := ensurePullSecret(ctx, cs, w, "", "")
+	require.NoError(t, err)
+	require.Empty(t, secretName)
+	_, err = ensurePullSecret(ctx, cs, w, "missing", "")
+	require.EqualError(t, err, "image pull secret missing does not exist in namespace default")
+
+	dockerConfigPath := filepath.Join(t.TempDir(), "config.json")
+	dockerConfig := []byte(`{"auths":{"registry.example.com":{"auth":"Zm9vOmJhcg=="}}}`)
+	err = os.WriteFile(dockerConfigPath, dockerConfig, 0o600)
+	require.NoError(t, err)
+	for range 2 {
+		secretName, err = ensurePullSecret(ctx, cs, w, "", dockerConfigPath)
+		require.NoError(t, err)
+		require.Equal(t, defaultPullSecretName, secretName)
+	}
+	secret, err := cs.CoreV1().Secrets("default").Get(ctx, defaultPullSecretName, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, corev1.SecretTypeDockerConfigJson, secret.Type)
+	require.Equal(t, dockerConfig, secret.Data[corev1.DockerConfigJsonKey])
+	require.Equal(t, "123", secret.Labels[runIDLabel])
+
+	secretName, err = ensurePullSecret(ctx, cs, w, defaultPullSecretName, "")
+	require.NoError(t, err)
+	require.Equal(t, defaultPullSecretName, secretName)
+
+	_, err = cs.CoreV1().Secrets("default").Create(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "user-secret"}}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = ensurePullSecret(ctx, cs, w, "user-secret", dockerConfigPath)
+	require.EqualError(t, err, "image pull secret and docker config cannot both be set")
+	secret, err = cs.CoreV1().Secrets("default").Get(ctx, "user-secret", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Empty(t, secret.Data)
+}
+
+func TestRegistryKeychain(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	cs := fake.NewClientset()
+	dockerConfigPath := filepath.Join(t.TempDir(), "config.json")
+	dockerConfig := []byte(`{"auths":{"https://registry.example.com/v1/":{"auth":"Zm9vOmJhcg=="},"docker.io":{"username":"hub","password":"secret"}}}`)
+	err := os.WriteFile(dockerConfigPath, dockerConfig, 0o600)
+	require.NoError(t, err)
+	_, err = cs.CoreV1().Secrets("default").Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "pull-secret"},
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: dockerConfig},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	fromFile, err := newRegistryKeychain(ctx, cs, "default", "", dockerConfigPath)
+	require.NoError(t, err)
+	fromSecret, err := newRegistryKeychain(ctx, cs, "default", "pull-secret", "")
+	require.NoError(t, err)
+	for _, keychain := range []registryKeychain{fromFile, fromSecret} {
+		ref, err := name.ParseReference("registry.example.com/benchmark:v1")
+		require.NoError(t, err)
+		auth, err := keychain.Resolve(ref.Context())
+		require.NoError(t, err)
+		cfg, err := auth.Authorization()
+		require.NoError(t, err)
+		require.Equal(t, "foo", cfg.Username)
+		require.Equal(t, "bar", cfg.Password)
+
+		ref, err = name.ParseReference("library/busybox")
+		require.NoError(t, err)
+		auth, err = keychain.Resolve(ref.Context())
+		require.NoError(t, err)
+		cfg, err = auth.Authorization()
+		require.NoError(t, err)
+		require.Equal(t, "hub", cfg.Username)
+	}
+
+	_, err = newRegistryKeychain(ctx, cs, "default", "missing", "")
+	require.Error(t, err)
+}
+
+func TestDeleteResources(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	oldRun := Workload{Namespace: "default", Name: "old", RunID: "1"}
+	newRun := Workload{Namespace: "default", Name: "new", RunID: "2"}
+	cs := fake.NewClientset(
+		&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: oldRun.Name, Namespace: "default", Labels: oldRun.labels(oldRun.Name)}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: clearImageName, Namespace: "default", Labels: oldRun.labels(clearImageName)}},
+		&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: newRun.Name, Namespace: "default", Labels: newRun.labels(newRun.Name)}},
+		&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "unrelated", Namespace: "default"}},
+	)
+
+	runIDs, err := staleRunIDs(ctx, cs, "default", newRun.RunID)
+	require.NoError(t, err)
+	require.Equal(t, []string{oldRun.RunID}, runIDs)
+
+	err = deleteResources(ctx, cs, "default", staleSelector(newRun.RunID))
+	require.NoError(t, err)
+	dsList, err := cs.AppsV1().DaemonSets("default").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	dsNames := []string{}
+	for _, ds := range dsList.Items {
+		dsNames = append(dsNames, ds.Name)
+	}
+	require.ElementsMatch(t, []string{"new", "unrelated"}, dsNames)
+	cmList, err := cs.CoreV1().ConfigMaps("default").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Empty(t, cmList.Items)
+	runIDs, err = staleRunIDs(ctx, cs, "default", newRun.RunID)
+	require.NoError(t, err)
+	require.Empty(t, runIDs)
+}
+
+func TestNewRunID(t *testing.T) {
+	t.Parallel()
+
+	require.NotEqual(t, newRunID(), newRunID())
+	require.Regexp(t, `^[0-9]+-[a-z0-9]{5}$`, newRunID())
+}
+
+func TestPodSecurityAllowsHostPath(t *testing.T) {
+	t.Parallel()
+
+	for _, labels := range []map[string]string{nil, {podSecurityEnforceLabel: "privileged"}} {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: labels}}
+		require.NoError(t, podSecurityAllowsHostPath(ns))
+	}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: map[string]string{podSecurityEnforceLabel: "baseline"}}}
+	require.EqualError(t, podSecurityAllowsHostPath(ns), "namespace default enforces the baseline pod security level which does not allow host path volumes, set pod-security.kubernetes.io/enforce=privileged")
+}
+
+func TestIsNodeReady(t *testing.T) {
+	t.Parallel()
+
+	node := corev1.Node{}
+	require.False(t, isNodeReady(node))
+	node.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+	require.True(t, isNodeReady(node))
+	node.Spec.Unschedulable = true
+	require.False(t, isNodeReady(node))
+}
+
+func TestCheckImageCleaner(t *testing.T) {
+	t.Parallel()
+
+	cs := fake.NewClientset()
+	check := checkImageCleaner(t.Context(), cs, nil, Options{Namespace: "spegel-benchmark", ImageCleaner: CrictlCleaner{}}, "node-1")
+	require.EqualError(t, check.Err, "namespace spegel-benchmark does not exist, create it to test run the image cleaner")
+	_, err := cs.CoreV1().Namespaces().Get(t.Context(), "spegel-benchmark", metav1.GetOptions{})
+	require.Error(t, err)
+
+	images := []string{preflightImage}
+	cleaner := preflightCleaner{CrictlCleaner{}}
+	require.Equal(t, "true", cleaner.Command(images))
+	require.Equal(t, CrictlCleaner{}.ProbeCommand(), cleaner.ProbeCommand())
+	require.Equal(t, CrictlCleaner{}.VerifyCommand(images, nil), cleaner.VerifyCommand(images, nil))
+	require.Empty(t, preflightCleaner{NoopCleaner{}}.Command(images))
+}
+
+func TestWritePreflightReport(t *testing.T) {
+	t.Parallel()
+
+	checks := []PreflightCheck{
+		{Name: "nodes", Message: "2 schedulable nodes are ready"},
+		{Name: "permissions", Err: errors.New("missing permissions " + formatPermission(authorizationv1.ResourceAttributes{Verb: "update", Group: "apps", Resource: "deployments", Subresource: "scale"}))},
+	}
+	buf := &bytes.Buffer{}
+	failed, err := writePreflightReport(buf, checks)
+	require.NoError(t, err)
+	require.Equal(t, 1, failed)
+	require.Equal(t, "[PASS] nodes: 2 schedulable nodes are ready\n[FAIL] permissions: missing permissions update deployments.apps/scale\n", buf.String())
+}
+
+func TestRequiredPermissions(t *testing.T) {
+	t.Parallel()
+
+	eviction := authorizationv1.ResourceAttributes{Verb: "create", Resource: "pods", Subresource: "eviction"}
+	proxy := authorizationv1.ResourceAttributes{Verb: "get", Resource: "pods", Subresource: "proxy"}
+
+	perms := requiredPermissions(Options{Namespace: "spegel-benchmark"})
+	require.Contains(t, perms, authorizationv1.ResourceAttributes{Verb: "update", Group: "apps", Resource: "deployments", Namespace: "spegel-benchmark"})
+	require.Contains(t, perms, authorizationv1.ResourceAttributes{Verb: "update", Group: "apps", Resource: "deployments", Subresource: "scale", Namespace: "spegel-benchmark"})
+	require.Contains(t, perms, authorizationv1.ResourceAttributes{Verb: "list", Group: "apps", Resource: "daemonsets"})
+	require.NotContains(t, perms, proxy)
+	require.NotContains(t, perms, eviction)
+
+	perms = requiredPermissions(Options{
+		Namespace:      "spegel-benchmark",
+		SpegelSelector: "app.kubernetes.io/name=spegel",
+		Chaos:          []ChaosStep{{Fault: &DrainNode{NodeName: "node-1"}}},
+	})
+	require.Contains(t, perms, proxy)
+	require.Contains(t, perms, eviction)
+	require.Contains(t, perms, authorizationv1.ResourceAttributes{Verb: "update", Resource: "nodes"})
+	listPods := 0
+	for _, attrs := range perms {
+		if attrs == (authorizationv1.ResourceAttributes{Verb: "list", Resource: "pods"}) {
+			listPods++
+		}
+	}
+	require.Equal(t, 1, listPods)
+}
+
+func TestNewNode(t *testing.T) {
+	t.Parallel()
+
+	node := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-a",
+			Labels: map[string]string{
+				corev1.LabelInstanceTypeStable: "m5.large",
+				corev1.LabelTopologyRegion:     "eu-west-1",
+				corev1.LabelTopologyZone:       "eu-west-1a",
+			},
+		},
+		Status: corev1.NodeStatus{
+			NodeInfo: corev1.NodeSystemInfo{
+				Architecture:            "arm64",
+				OperatingSystem:         "linux",
+				OSImage:                 "Ubuntu 24.04 LTS",
+				KernelVersion:           "6.8.0",
+				ContainerRuntimeVersion: "containerd://1.7.20",
+				KubeletVersion:          "v1.31.0",
+			},
+		},
+	}
+	expected := Node{
+		Name:                    "node-a",
+		InstanceType:            "m5.large",
+		Architecture:            "arm64",
+		OperatingSystem:         "linux",
+		OSImage:                 "Ubuntu 24.04 LTS",
+		KernelVersion:           "6.8.0",
+		ContainerRuntimeVersion: "containerd://1.7.20",
+		KubeletVersion:          "v1.31.0",
+		Region:                  "eu-west-1",
+		Zone:                    "eu-west-1a",
+	}
+	require.Equal(t, expected, newNode(node))
+}
+
+func TestDetectCNI(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "cilium", detectCNI([]string{"kube-proxy", "cilium", "cilium-envoy"}))
+	require.Equal(t, "canal", detectCNI([]string{"canal", "calico-node"}))
+	require.Equal(t, "flannel", detectCNI([]string{"kube-flannel-ds"}))
+	require.Empty(t, detectCNI([]string{"kube-proxy"}))
+
+	cs := fake.NewClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}},
+		&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "kube-proxy", Namespace: metav1.NamespaceSystem}},
+		&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "calico-node", Namespace: "calico-system"}},
+	)
+	cluster, err := clusterInfo(t.Context(), cs)
+	require.NoError(t, err)
+	require.Equal(t, "calico", cluster.CNI)
+	require.Equal(t, 1, cluster.NodeCount)
+}
+
+func TestDetectSpegel(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	selector := "app.kubernetes.io/name=spegel"
+	cs := fake.NewClientset()
+	spegel, err := detectSpegel(ctx, cs, selector)
+	require.NoError(t, err)
+	require.False(t, spegel.Detected)
+
+	ds := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "spegel",
+			Namespace: "spegel",
+			Labels:    map[string]string{"app.kubernetes.io/name": "spegel"},
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{
+						{
+							Name:  "configuration",
+							Image: "ghcr.io/spegel-org/spegel:v0.0.30@sha256:abc",
+							Args:  []string{"configuration", "--mirror-registries", "http://$(NODE_IP):30020", "--mirrored-registries", "https://docker.io", "https://ghcr.io"},
+						},
+					},
+					Containers: []corev1.Container{
+						{
+							Name:  "registry",
+							Image: "ghcr.io/spegel-org/spegel:v0.0.30@sha256:abc",
+							Args:  []string{"registry", "--registry-addr=:5000", "--mirrored-registries=https://docker.io,https://ghcr.io"},
+						},
+					},
+				},
+			},
+		},
+	}
+	cs = fake.NewClientset(ds)
+	spegel, err = detectSpegel(ctx, cs, selector)
+	require.NoError(t, err)
+	require.True(t, spegel.Detected)
+	require.Equal(t, "spegel", spegel.Namespace)
+	require.Equal(t, "ghcr.io/spegel-org/spegel:v0.0.30@sha256:abc", spegel.Image)
+	require.Equal(t, "v0.0.30", spegel.Version)
+	require.Equal(t, []string{"https://docker.io", "https://ghcr.io"}, spegel.MirroredRegistries)
+	require.Equal(t, []string{"http://$(NODE_IP):30020"}, spegel.MirrorTargets)
+	require.Equal(t, []string{"registry", "--registry-addr=:5000", "--mirrored-registries=https://docker.io,https://ghcr.io"}, spegel.Args["registry"])
+
+	renamed := ds.DeepCopy()
+	renamed.Spec.Template.Spec.InitContainers[0].Args = []string{"configuration", "--mirror-targets", "http://$(NODE_IP):30020", "http://$(NODE_IP):30021", "--mirrored-registries", "https://docker.io"}
+	cs = fake.NewClientset(renamed)
+	spegel, err = detectSpegel(ctx, cs, selector)
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://$(NODE_IP):30020", "http://$(NODE_IP):30021"}, spegel.MirrorTargets)
+
+	other := ds.DeepCopy()
+	other.Namespace = "default"
+	cs = fake.NewClientset(ds, other)
+	_, err = detectSpegel(ctx, cs, selector)
+	require.EqualError(t, err, "found multiple Spegel DaemonSets default/spegel, spegel/spegel matching selector app.kubernetes.io/name=spegel")
+}
+
+func TestImageVersion(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "v0.0.30", imageVersion("ghcr.io/spegel-org/spegel:v0.0.30"))
+	require.Equal(t, "v0.0.30", imageVersion("ghcr.io/spegel-org/spegel:v0.0.30@sha256:abc"))
+	require.Empty(t, imageVersion("localhost:5000/spegel"))
+	require.Empty(t, imageVersion("ghcr.io/spegel-org/spegel@sha256:abc"))
+}
+
+const spegelMetrics = `# HELP spegel_mirror_requests_total Total number of mirror requests.
+# TYPE spegel_mirror_requests_total counter
+spegel_mirror_requests_total{cache="hit",registry="ghcr.io",source="internal"} 4
+spegel_mirror_requests_total{cache="miss",registry="ghcr.io",source="external"} 1
+# HELP http_response_size_bytes The size of the HTTP responses.
+# TYPE http_response_size_bytes histogram
+http_response_size_bytes_bucket{handler="mirror",le="+Inf"} 5
+http_response_size_bytes_sum{handler="mirror"} 1.048576e+06
+http_response_size_bytes_count{handler="mirror"} 5
+# HELP spegel_advertised_images Number of images advertised to be available.
+# TYPE spegel_advertised_images gauge
+spegel_advertised_images{registry="ghcr.io"} 12
+`
+
+func TestParseMetrics(t *testing.T) {
+	t.Parallel()
+
+	metrics, err := parseMetrics(strings.NewReader(spegelMetrics))
+	require.NoError(t, err)
+	expected := map[string]float64{
+		`spegel_mirror_requests_total{cache="hit",registry="ghcr.io",source="internal"}`:  4,
+		`spegel_mirror_requests_total{cache="miss",registry="ghcr.io",source="external"}`: 1,
+		`http_response_size_bytes_sum{handler="mirror"}`:                                  1048576,
+		`http_response_size_bytes_count{handler="mirror"}`:                                5,
+	}
+	require.Equal(t, expected, metrics)
+
+	openMetrics := `# TYPE spegel_mirror_requests counter
+spegel_mirror_requests_total{source="internal"} 4
+spegel_mirror_requests_created{source="internal"} 1.7e+09
+# EOF
+`
+	metrics, err = parseMetrics(strings.NewReader(openMetrics))
+	require.NoError(t, err)
+	require.Equal(t, map[string]float64{`spegel_mirror_requests_total{source="internal"}`: 4}, metrics)
+
+	_, err = parseMetrics(strings.NewReader("foo{bar=\"baz\"}"))
+	require.EqualError(t, err, "metric line \"foo{bar=\\\"baz\\\"}\" is missing a value")
+}
+
+func TestMetricsDelta(t *testing.T) {
+	t.Parallel()
+
+	before := map[string]map[string]float64{
+		"spegel/a": {"a": 1, "b": 5, "c": 2},
+		"spegel/b": {"a": 10, "b": 10},
+	}
+	after := map[string]map[string]float64{
+		"spegel/a": {"a": 3, "b": 2, "c": 2, "d": 1},
+		"spegel/b": {"a": 11, "b": 12},
+		"spegel/c": {"a": 4},
+	}
+	// Pod a restarted so b starts from zero, pod c started during the measurement.
+	require.Equal(t, map[string]float64{"a": 7, "b": 4, "d": 1}, metricsDelta(before, after))
+	require.Nil(t, metricsDelta(before, nil))
+}
+
+func TestScrapeMetrics(t *testing.T) {
+	t.Parallel()
+
+	pod := func(name string) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "spegel"},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{Name: "registry", Ports: []corev1.ContainerPort{{Name: "metrics", ContainerPort: 9090}}},
+				},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+	}
+	podList := corev1.PodList{Items: []corev1.Pod{pod("spegel-a"), pod("spegel-b"), pod("spegel-c")}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/pods", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("labelSelector") != "app.kubernetes.io/name=spegel" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		//nolint: errcheck // Ignore error.
+		json.NewEncoder(w).Encode(podList)
+	})
+	mux.HandleFunc("GET /api/v1/namespaces/spegel/pods/{pod}/proxy/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.PathValue("pod"), ":9090") || strings.Contains(r.PathValue("pod"), "spegel-c") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		//nolint: errcheck // Ignore error.
+		w.Write([]byte(spegelMetrics))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	cs, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	require.NoError(t, err)
+
+	metrics, err := scrapeMetrics(t.Context(), cs, "app.kubernetes.io/name=spegel")
+	require.ErrorContains(t, err, "could not scrape metrics from pod spegel/spegel-c")
+	require.Len(t, metrics, 2)
+	require.InDelta(t, 4.0, metrics["spegel/spegel-a"][`spegel_mirror_requests_total{cache="hit",registry="ghcr.io",source="internal"}`], 0)
+	require.InDelta(t, 1048576.0, metrics["spegel/spegel-b"][`http_response_size_bytes_sum{handler="mirror"}`], 0)
+
+	metrics, err = scrapeMetrics(t.Context(), cs, "")
+	require.NoError(t, err)
+	require.Nil(t, metrics)
+}
+
+func TestScrapeNodeMetrics(t *testing.T) {
+	t.Parallel()
+
+	cadvisorMetrics := `# TYPE container_network_receive_bytes_total counter
+container_network_receive_bytes_total{container="",id="/",interface="eth0",pod=""} 1024 1700000000000
+container_network_receive_bytes_total{container="registry",id="/kubepods/pod1",interface="eth0",pod="spegel-a"} 512 1700000000000
+`
+	nodeList := corev1.NodeList{Items: []corev1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}, {ObjectMeta: metav1.ObjectMeta{Name: "node-b"}}}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/nodes", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		//nolint: errcheck // Ignore error.
+		json.NewEncoder(w).Encode(nodeList)
+	})
+	mux.HandleFunc("GET /api/v1/nodes/{node}/proxy/metrics/cadvisor", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("node") != "node-a" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		//nolint: errcheck // Ignore error.
+		w.Write([]byte(cadvisorMetrics))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	cs, err := kubernetes.NewForConfig(&rest.Config{Host: srv.URL})
+	require.NoError(t, err)
+
+	metrics, err := scrapeNodeMetrics(t.Context(), cs)
+	require.ErrorContains(t, err, "could not scrape metrics from node node-b")
+	expected := map[string]map[string]float64{
+		"node-a": {`container_network_receive_bytes_total{container="",id="/",interface="eth0",pod=""}`: 1024},
+	}
+	require.Equal(t, expected, metrics)
+}
+
+func TestDeleteSpegelPods(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	objs := []runtime.Object{
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "unrelated", Namespace: "default"}},
+	}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		objs = append(objs, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "spegel-" + name, Namespace: "spegel", Labels: map[string]string{"app.kubernetes.io/name": "spegel"}}})
+	}
+	cs := fake.NewClientset(objs...)
+	fault := DeleteSpegelPods{Selector: "app.kubernetes.io/name=spegel", Percent: 50}
+	msg, err := fault.Inject(ctx, cs)
+	require.NoError(t, err)
+	require.Contains(t, msg, "deleted 2 of 4 pods")
+	podList, err := cs.CoreV1().Pods("spegel").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, podList.Items, 2)
+	_, err = cs.CoreV1().Pods("default").Get(ctx, "unrelated", metav1.GetOptions{})
+	require.NoError(t, err)
+}
+
+func TestDrainNode(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}
+	cs := fake.NewClientset(node)
+	fault := &DrainNode{NodeName: "node-a"}
+	msg, err := fault.Inject(ctx, cs)
+	require.NoError(t, err)
+	require.Equal(t, "cordoned node node-a and evicted 0 pods", msg)
+	node, err = cs.CoreV1().Nodes().Get(ctx, "node-a", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.True(t, node.Spec.Unschedulable)
+
+	err = fault.Recover(ctx, cs)
+	require.NoError(t, err)
+	node, err = cs.CoreV1().Nodes().Get(ctx, "node-a", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.False(t, node.Spec.Unschedulable)
+}
+
+func TestDrainNodeSpegel(t *testing.T) {
+	t.Parallel()
+
+	ctx := t.Context()
+	spegelPod := func(name, nodeName string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       "spegel",
+				Labels:          map[string]string{"app": "spegel"},
+				OwnerReferences: []metav1.OwnerReference{{Kind: "DaemonSet"}},
+			},
+			Spec: corev1.PodSpec{NodeName: nodeName},
+		}
+	}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}
+	cs := fake.NewClientset(node, spegelPod("spegel-a", "node-a"), spegelPod("spegel-b", "node-b"))
+	fault := &DrainNode{NodeName: "node-a", SpegelSelector: "app=spegel"}
+	msg, err := fault.Inject(ctx, cs)
+	require.NoError(t, err)
+	require.Equal(t, "cordoned node node-a and evicted 0 pods, deleted Spegel pods spegel/spegel-a", msg)
+	podList, err := cs.CoreV1().Pods("spegel").List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, podList.Items, 1)
+	require.Equal(t, "spegel-b", podList.Items[0].Name)
+}
+
+func TestIsEvictable(t *testing.T) {
+	t.Parallel()
+
+	require.True(t, isEvictable(corev1.Pod{}))
+	require.False(t, isEvictable(corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodSucceeded}}))
+	require.False(t, isEvictable(corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{corev1.MirrorPodAnnotationKey: "foo"}}}))
+	require.False(t, isEvictable(corev1.Pod{ObjectMeta: metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{{Kind: "DaemonSet"}}}}))
+}
+
+type testFault struct {
+	recovered *bool
+}
+
+func (testFault) Name() string {
+	return "test"
+}
+
+func (testFault) Inject(_ context.Context, _ kubernetes.Interface) (string, error) {
+	return "injected", nil
+}
+
+func (f testFault) Recover(_ context.Context, _ kubernetes.Interface) error {
+	*f.recovered = true
+	return nil
+}
+
+func TestChaosRun(t *testing.T) {
+	t.Parallel()
+
+	injectedRecovered := false
+	pendingRecovered := false
+	steps := []ChaosStep{
+		{Fault: testFault{recovered: &injectedRecovered}, After: 10 * time.Millisecond},
+		{Fault: testFault{recovered: &pendingRecovered}, After: time.Hour},
+	}
+	chaos := startChaos(t.Context(), fake.NewClientset(), steps)
+	time.Sleep(100 * time.Millisecond)
+	events, err := chaos.stop(t.Context())
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, "test", events[0].Fault)
+	require.Equal(t, "injected", events[0].Message)
+	require.GreaterOrEqual(t, events[0].After, 10*time.Millisecond)
+	require.False(t, events[0].Skipped)
+	require.True(t, events[1].Skipped)
+	require.Equal(t, time.Hour, events[1].After)
+	require.True(t, injectedRecovered)
+	require.True(t, pendingRecovered)
+}
@@ -0,0 +1,201 @@
+package measure
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const metricsPortName = "metrics"
+
+// scrapeMetrics scrapes the metrics of the running pods matching the selector, keyed by pod.
+// Pods which could not be scraped are left out and returned as an error.
+func scrapeMetrics(ctx context.Context, cs kubernetes.Interface, selector string) (map[string]map[string]float64, error) {
+	if selector == "" {
+		return nil, nil
+	}
+	podList, err := cs.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	metrics := map[string]map[string]float64{}
+	errs := []error{}
+	for _, pod := range podList.Items {
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		port, ok := metricsPort(pod)
+		if !ok {
+			continue
+		}
+		b, err := cs.CoreV1().Pods(pod.Namespace).ProxyGet("http", pod.Name, port, "/metrics", nil).DoRaw(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not scrape metrics from pod %s/%s: %w", pod.Namespace, pod.Name, err))
+			continue
+		}
+		podMetrics, err := parseMetrics(bytes.NewReader(b))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not parse metrics from pod %s/%s: %w", pod.Namespace, pod.Name, err))
+			continue
+		}
+		metrics[pod.Namespace+"/"+pod.Name] = podMetrics
+	}
+	return metrics, errors.Join(errs...)
+}
+
+// scrapeNodeMetrics scrapes the cAdvisor metrics of each node through the API server node proxy, keyed by node.
+// Only the series of the root cgroup are kept as they cover the whole node, such as the network bytes received.
+// Nodes which could not be scraped are left out and returned as an error.
+func scrapeNodeMetrics(ctx context.Context, cs kubernetes.Interface) (map[string]map[string]float64, error) {
+	nodeList, err := cs.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	metrics := map[string]map[string]float64{}
+	errs := []error{}
+	for _, node := range nodeList.Items {
+		b, err := cs.CoreV1().RESTClient().Get().AbsPath("/api/v1/nodes", node.Name, "proxy", "metrics", "cadvisor").DoRaw(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not scrape metrics from node %s: %w", node.Name, err))
+			continue
+		}
+		nodeMetrics, err := parseMetrics(bytes.NewReader(b))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not parse metrics from node %s: %w", node.Name, err))
+			continue
+		}
+		for series := range nodeMetrics {
+			if !strings.Contains(series, `{id="/"`) && !strings.Contains(series, `,id="/"`) {
+				delete(nodeMetrics, series)
+			}
+		}
+		metrics[node.Name] = nodeMetrics
+	}
+	return metrics, errors.Join(errs...)
+}
+
+func metricsPort(pod corev1.Pod) (string, bool) {
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Name == metricsPortName {
+				return strconv.FormatInt(int64(port.ContainerPort), 10), true
+			}
+		}
+	}
+	return "", false
+}
+
+// parseMetrics parses the Prometheus text format and returns the counters along with the sum and count of histograms and summaries.
+// Gauges are ignored as the difference between two scrapes is not meaningful for them.
+func parseMetrics(r io.Reader) (map[string]float64, error) {
+	types := map[string]string{}
+	metrics := map[string]float64{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) == 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+
+		series, value, err := parseMetricLine(line)
+		if err != nil {
+			return nil, err
+		}
+		name, _, _ := strings.Cut(series, "{")
+		if !isCumulative(name, types) {
+			continue
+		}
+		metrics[series] = value
+	}
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// parseMetricLine splits a sample line into the series, which is the name with labels, and the value.
+func parseMetricLine(line string) (string, float64, error) {
+	series := ""
+	rest := ""
+	if i := strings.LastIndex(line, "}"); i != -1 {
+		series = line[:i+1]
+		rest = line[i+1:]
+	} else {
+		var ok bool
+		series, rest, ok = strings.Cut(line, " ")
+		if !ok {
+			return "", 0, fmt.Errorf("invalid metric line %q", line)
+		}
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return "", 0, fmt.Errorf("metric line %q is missing a value", line)
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid value in metric line %q: %w", line, err)
+	}
+	return series, value, nil
+}
+
+func isCumulative(name string, types map[string]string) bool {
+	if types[name] == "counter" {
+		return true
+	}
+	// OpenMetrics names the counter family without the total suffix of its samples.
+	base, ok := strings.CutSuffix(name, "_total")
+	if ok && types[base] == "counter" {
+		return true
+	}
+	for _, suffix := range []string{"_sum", "_count"} {
+		base, ok := strings.CutSuffix(name, suffix)
+		if ok && (types[base] == "histogram" || types[base] == "summary") {
+			return true
+		}
+	}
+	return false
+}
+
+// metricsDelta sums the change of each series across pods. Series of restarted or new pods start from zero.
+func metricsDelta(before, after map[string]map[string]float64) map[string]float64 {
+	if len(after) == 0 {
+		return nil
+	}
+	delta := map[string]float64{}
+	for pod, podMetrics := range after {
+		for k, v := range podMetrics {
+			d := v - before[pod][k]
+			if d < 0 {
+				d = v
+			}
+			if math.IsNaN(d) {
+				continue
+			}
+			delta[k] += d
+		}
+	}
+	for k, d := range delta {
+		if d == 0 {
+			delete(delta, k)
+		}
+	}
+	return delta
+}
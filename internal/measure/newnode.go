@@ -0,0 +1,124 @@
+package measure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/go-logr/logr"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// NewNodeScenario simulates nodes joining a cluster where peers already have the image.
+// The image is first pulled on all nodes, then removed from the cold nodes only, after
+// which pods are scheduled on the cold nodes. Samples are only collected from the cold nodes.
+type NewNodeScenario struct {
+	// NodeNames are the nodes to clear the image from. When empty ColdNodeCount nodes are chosen.
+	NodeNames []string
+	// ColdNodeCount is the amount of nodes to clear when no node names are given.
+	ColdNodeCount int
+}
+
+func (NewNodeScenario) Name() string {
+	return "new-node"
+}
+
+func (s NewNodeScenario) Measure(ctx context.Context, cs kubernetes.Interface, dc dynamic.Interface, w Workload, image string) ([]Sample, error) {
+	log := logr.FromContextOrDiscard(ctx).WithValues("image", image)
+	log.Info("measuring pull performance")
+
+	coldNodes, err := s.coldNodes(ctx, cs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Warm up all nodes so that the image is present on every peer.
+	log.Info("warming up nodes")
+	warmName := warmDaemonSetName(w.Name)
+	err = rolloutDaemonSet(ctx, cs, dc, w, warmName, image, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = deleteAndWait(ctx, cs, w.Namespace, warmName)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = removeImages(ctx, cs, dc, w, []string{image}, coldNodes)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("scheduling pods on cold nodes", "nodes", coldNodes)
+	err = rolloutDaemonSet(ctx, cs, dc, w, w.Name, image, coldNodes)
+	if err != nil {
+		return nil, err
+	}
+	return collectSamples(ctx, cs, w.Namespace, fmt.Sprintf("app=%s", w.Name), false)
+}
+
+func (NewNodeScenario) Cleanup(ctx context.Context, cs kubernetes.Interface, w Workload) error {
+	errs := []error{}
+	for _, dsName := range []string{w.Name, warmDaemonSetName(w.Name)} {
+		err := cs.AppsV1().DaemonSets(w.Namespace).Delete(ctx, dsName, metav1.DeleteOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s NewNodeScenario) coldNodes(ctx context.Context, cs kubernetes.Interface) ([]string, error) {
+	nodes, err := benchmarkNodes(ctx, cs)
+	if err != nil {
+		return nil, err
+	}
+	nodeNames := []string{}
+	for _, node := range nodes {
+		nodeNames = append(nodeNames, node.Name)
+	}
+	return selectColdNodes(nodeNames, s.NodeNames, s.ColdNodeCount)
+}
+
+// selectColdNodes validates the requested cold nodes or picks the last nodes by name.
+// At least one warm node has to remain for the cold nodes to pull from.
+func selectColdNodes(nodeNames, requested []string, count int) ([]string, error) {
+	if len(requested) > 0 {
+		for _, nodeName := range requested {
+			if !slices.Contains(nodeNames, nodeName) {
+				return nil, fmt.Errorf("cold node %s is not a schedulable node", nodeName)
+			}
+		}
+		count = len(requested)
+	}
+	if count < 1 {
+		return nil, errors.New("at least one cold node is required")
+	}
+	if count >= len(nodeNames) {
+		return nil, fmt.Errorf("cold node count %d leaves no warm nodes out of %d nodes", count, len(nodeNames))
+	}
+	if len(requested) > 0 {
+		return requested, nil
+	}
+	sorted := slices.Clone(nodeNames)
+	slices.Sort(sorted)
+	return sorted[len(sorted)-count:], nil
+}
+
+func warmDaemonSetName(name string) string {
+	return name + "-warm"
+}
+
+// deleteAndWait deletes the DaemonSet and waits for all of its pods to be removed.
+func deleteAndWait(ctx context.Context, cs kubernetes.Interface, namespace, name string) error {
+	propagation := metav1.DeletePropagationForeground
+	err := cs.AppsV1().DaemonSets(namespace).Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil {
+		return err
+	}
+	return waitForPodsDeleted(ctx, cs, namespace, fmt.Sprintf("app=%s", name))
+}
@@ -0,0 +1,340 @@
+package measure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	podSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+	// preflightImage does not exist and is used to test run the image cleaner without removing anything.
+	preflightImage = "ghcr.io/spegel-org/benchmark:preflight"
+)
+
+// PreflightCheck is the result of a single preflight check.
+type PreflightCheck struct {
+	Err     error
+	Name    string
+	Message string
+}
+
+// requiredPermissions returns the permissions used by a benchmark, without a namespace for cluster wide access.
+func requiredPermissions(opts Options) []authorizationv1.ResourceAttributes {
+	ns := opts.Namespace
+	perms := []authorizationv1.ResourceAttributes{
+		{Verb: "get", Resource: "namespaces"},
+		{Verb: "create", Resource: "namespaces"},
+		{Verb: "list", Resource: "nodes"},
+		{Verb: "list", Resource: "pods", Namespace: ns},
+		{Verb: "get", Resource: "pods", Subresource: "log", Namespace: ns},
+		{Verb: "list", Resource: "events", Namespace: ns},
+		{Verb: "list", Resource: "configmaps", Namespace: ns},
+		{Verb: "create", Resource: "configmaps", Namespace: ns},
+		{Verb: "update", Resource: "configmaps", Namespace: ns},
+		{Verb: "delete", Resource: "configmaps", Namespace: ns},
+		{Verb: "list", Resource: "secrets", Namespace: ns},
+		{Verb: "get", Resource: "secrets", Namespace: ns},
+		{Verb: "create", Resource: "secrets", Namespace: ns},
+		{Verb: "update", Resource: "secrets", Namespace: ns},
+		{Verb: "delete", Resource: "secrets", Namespace: ns},
+		{Verb: "list", Group: "apps", Resource: "daemonsets", Namespace: ns},
+		{Verb: "get", Group: "apps", Resource: "daemonsets", Namespace: ns},
+		{Verb: "create", Group: "apps", Resource: "daemonsets", Namespace: ns},
+		{Verb: "update", Group: "apps", Resource: "daemonsets", Namespace: ns},
+		{Verb: "delete", Group: "apps", Resource: "daemonsets", Namespace: ns},
+		{Verb: "list", Group: "apps", Resource: "deployments", Namespace: ns},
+		{Verb: "get", Group: "apps", Resource: "deployments", Namespace: ns},
+		{Verb: "create", Group: "apps", Resource: "deployments", Namespace: ns},
+		{Verb: "update", Group: "apps", Resource: "deployments", Namespace: ns},
+		{Verb: "update", Group: "apps", Resource: "deployments", Subresource: "scale", Namespace: ns},
+		{Verb: "delete", Group: "apps", Resource: "deployments", Namespace: ns},
+		{Verb: "list", Group: "batch", Resource: "jobs", Namespace: ns},
+		{Verb: "get", Group: "batch", Resource: "jobs", Namespace: ns},
+		{Verb: "create", Group: "batch", Resource: "jobs", Namespace: ns},
+		{Verb: "delete", Group: "batch", Resource: "jobs", Namespace: ns},
+		// Spegel and the CNI are detected from their DaemonSets.
+		{Verb: "list", Group: "apps", Resource: "daemonsets"},
+	}
+	// Metrics are scraped from the Spegel pods through the API server proxy.
+	if opts.SpegelSelector != "" {
+		perms = append(perms,
+			authorizationv1.ResourceAttributes{Verb: "list", Resource: "pods"},
+			authorizationv1.ResourceAttributes{Verb: "get", Resource: "pods", Subresource: "proxy"},
+		)
+	}
+	// Node metrics are scraped through the API server node proxy.
+	if opts.NodeMetrics {
+		perms = append(perms,
+			authorizationv1.ResourceAttributes{Verb: "list", Resource: "nodes"},
+			authorizationv1.ResourceAttributes{Verb: "get", Resource: "nodes", Subresource: "proxy"},
+		)
+	}
+	if len(opts.Chaos) > 0 {
+		perms = append(perms,
+			authorizationv1.ResourceAttributes{Verb: "list", Resource: "pods"},
+			authorizationv1.ResourceAttributes{Verb: "delete", Resource: "pods"},
+			authorizationv1.ResourceAttributes{Verb: "create", Resource: "pods", Subresource: "eviction"},
+			authorizationv1.ResourceAttributes{Verb: "get", Resource: "nodes"},
+			authorizationv1.ResourceAttributes{Verb: "update", Resource: "nodes"},
+		)
+	}
+	result := []authorizationv1.ResourceAttributes{}
+	for _, attrs := range perms {
+		if slices.Contains(result, attrs) {
+			continue
+		}
+		result = append(result, attrs)
+	}
+	return result
+}
+
+// RunPreflight checks that the cluster is able to run benchmarks and writes a report to the writer.
+// When no images are given the suite images are checked. An error is returned if any check fails.
+func RunPreflight(ctx context.Context, opts Options, images []string, w io.Writer) error {
+	cfg, err := clientcmd.BuildConfigFromFlags("", opts.KubeconfigPath)
+	if err != nil {
+		return err
+	}
+	cs, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	dc, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if len(images) == 0 {
+		images = suiteImages()
+	}
+
+	checks := []PreflightCheck{}
+	checks = append(checks, checkPermissions(ctx, cs, requiredPermissions(opts)))
+	checks = append(checks, checkPodSecurity(ctx, cs, opts.Namespace))
+	nodeCheck, nodeNames := checkNodes(ctx, cs)
+	checks = append(checks, nodeCheck)
+	if len(nodeNames) > 0 {
+		checks = append(checks, checkImageCleaner(ctx, cs, dc, opts, nodeNames[0]))
+	}
+	keychain, err := newRegistryKeychain(ctx, cs, opts.Namespace, opts.ImagePullSecret, opts.DockerConfigPath)
+	if err != nil {
+		checks = append(checks, PreflightCheck{Name: "images", Err: fmt.Errorf("could not read registry credentials: %w", err)})
+	} else {
+		checks = append(checks, checkImages(ctx, keychain, images))
+	}
+
+	failed, err := writePreflightReport(w, checks)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d preflight checks failed", failed)
+	}
+	return nil
+}
+
+func writePreflightReport(w io.Writer, checks []PreflightCheck) (int, error) {
+	failed := 0
+	for _, check := range checks {
+		if check.Err != nil {
+			failed++
+			_, err := fmt.Fprintf(w, "[FAIL] %s: %v\n", check.Name, check.Err)
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+		_, err := fmt.Fprintf(w, "[PASS] %s: %s\n", check.Name, check.Message)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return failed, nil
+}
+
+func checkPermissions(ctx context.Context, cs kubernetes.Interface, perms []authorizationv1.ResourceAttributes) PreflightCheck {
+	check := PreflightCheck{Name: "permissions"}
+	denied := []string{}
+	for _, attrs := range perms {
+		ssar := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &attrs,
+			},
+		}
+		res, err := cs.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, ssar, metav1.CreateOptions{})
+		if err != nil {
+			check.Err = err
+			return check
+		}
+		if res.Status.Allowed {
+			continue
+		}
+		denied = append(denied, formatPermission(attrs))
+	}
+	if len(denied) > 0 {
+		check.Err = fmt.Errorf("missing permissions %s", strings.Join(denied, ", "))
+		return check
+	}
+	check.Message = fmt.Sprintf("all %d required permissions granted", len(perms))
+	return check
+}
+
+func formatPermission(attrs authorizationv1.ResourceAttributes) string {
+	resource := attrs.Resource
+	if attrs.Group != "" {
+		resource = fmt.Sprintf("%s.%s", resource, attrs.Group)
+	}
+	if attrs.Subresource != "" {
+		resource = fmt.Sprintf("%s/%s", resource, attrs.Subresource)
+	}
+	if attrs.Namespace == "" {
+		return fmt.Sprintf("%s %s", attrs.Verb, resource)
+	}
+	return fmt.Sprintf("%s %s in %s", attrs.Verb, resource, attrs.Namespace)
+}
+
+// checkPodSecurity verifies that Pod Security Admission allows the privileged host path pods used to remove images.
+func checkPodSecurity(ctx context.Context, cs kubernetes.Interface, namespace string) PreflightCheck {
+	check := PreflightCheck{Name: "pod security"}
+	ns, err := cs.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		check.Message = fmt.Sprintf("namespace %s does not exist and will be created", namespace)
+		return check
+	}
+	if err != nil {
+		check.Err = err
+		return check
+	}
+	check.Err = podSecurityAllowsHostPath(ns)
+	if check.Err == nil {
+		check.Message = fmt.Sprintf("namespace %s allows host path volumes", namespace)
+	}
+	return check
+}
+
+func podSecurityAllowsHostPath(ns *corev1.Namespace) error {
+	level, ok := ns.Labels[podSecurityEnforceLabel]
+	if !ok || level == "privileged" {
+		return nil
+	}
+	return fmt.Errorf("namespace %s enforces the %s pod security level which does not allow host path volumes, set %s=privileged", ns.Name, level, podSecurityEnforceLabel)
+}
+
+func checkNodes(ctx context.Context, cs kubernetes.Interface) (PreflightCheck, []string) {
+	check := PreflightCheck{Name: "nodes"}
+	nodes, err := benchmarkNodes(ctx, cs)
+	if err != nil {
+		check.Err = err
+		return check, nil
+	}
+	if len(nodes) == 0 {
+		check.Err = errors.New("no schedulable nodes without taints")
+		return check, nil
+	}
+	ready := []string{}
+	notReady := []string{}
+	for _, node := range nodes {
+		if !isNodeReady(node) {
+			notReady = append(notReady, node.Name)
+			continue
+		}
+		ready = append(ready, node.Name)
+	}
+	if len(notReady) > 0 {
+		check.Err = fmt.Errorf("nodes %s are not ready", strings.Join(notReady, ", "))
+		return check, ready
+	}
+	check.Message = fmt.Sprintf("%d schedulable nodes are ready", len(ready))
+	return check, ready
+}
+
+func isNodeReady(node corev1.Node) bool {
+	if node.Spec.Unschedulable {
+		return false
+	}
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == corev1.NodeReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// checkImageCleaner runs the probe and verify commands of the image cleaner on a single node without removing images.
+// It fails if the probe cannot reach the runtime, for example when crictl is not installed.
+func checkImageCleaner(ctx context.Context, cs kubernetes.Interface, dc dynamic.Interface, opts Options, nodeName string) PreflightCheck {
+	check := PreflightCheck{Name: "image cleaner"}
+	_, err := cs.CoreV1().Namespaces().Get(ctx, opts.Namespace, metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		check.Err = fmt.Errorf("namespace %s does not exist, create it to test run the image cleaner", opts.Namespace)
+		return check
+	}
+	if err != nil {
+		check.Err = err
+		return check
+	}
+	w := Workload{
+		Cleaner:   preflightCleaner{opts.ImageCleaner},
+		Namespace: opts.Namespace,
+		RunID:     newRunID(),
+	}
+	results, err := clearImages(ctx, cs, dc, w, []string{preflightImage}, nil, []string{nodeName})
+	if err != nil {
+		check.Err = err
+		return check
+	}
+	check.Message = fmt.Sprintf("%s cleaner reached the runtime and ran on node %s", opts.ImageCleaner.Name(), nodeName)
+	if len(results) == 0 {
+		check.Message = fmt.Sprintf("%s cleaner does not remove images", opts.ImageCleaner.Name())
+	}
+	return check
+}
+
+// checkImages verifies that the benchmark images can be resolved from the registry with the credentials used for benchmark pods.
+func checkImages(ctx context.Context, keychain authn.Keychain, images []string) PreflightCheck {
+	check := PreflightCheck{Name: "images"}
+	unresolved := []string{}
+	for _, image := range images {
+		ref, err := name.ParseReference(image)
+		if err != nil {
+			check.Err = err
+			return check
+		}
+		_, err = remote.Head(ref, remote.WithContext(ctx), remote.WithAuthFromKeychain(keychain))
+		if err != nil {
+			unresolved = append(unresolved, fmt.Sprintf("%s (%v)", image, err))
+		}
+	}
+	if len(unresolved) > 0 {
+		check.Err = fmt.Errorf("could not resolve images %s", strings.Join(unresolved, ", "))
+		return check
+	}
+	check.Message = fmt.Sprintf("all %d images resolve", len(images))
+	return check
+}
+
+// preflightCleaner runs the probe and verify commands of the cleaner but does not remove any images.
+type preflightCleaner struct {
+	ImageCleaner
+}
+
+func (c preflightCleaner) Command(images []string) string {
+	if c.ImageCleaner.Command(images) == "" {
+		return ""
+	}
+	return "true"
+}
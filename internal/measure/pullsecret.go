@@ -0,0 +1,127 @@
+package measure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const defaultPullSecretName = "spegel-benchmark-pull-secret"
+
+// ensurePullSecret returns the name of the pull secret to attach to benchmark pods, or an empty string if none is configured.
+// When a docker config path is given the benchmark secret is created or updated from it, otherwise the referenced secret has to exist.
+func ensurePullSecret(ctx context.Context, cs kubernetes.Interface, w Workload, secretName, dockerConfigPath string) (string, error) {
+	namespace := w.Namespace
+	if dockerConfigPath == "" {
+		if secretName == "" {
+			return "", nil
+		}
+		_, err := cs.CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+		if kerrors.IsNotFound(err) {
+			return "", fmt.Errorf("image pull secret %s does not exist in namespace %s", secretName, namespace)
+		}
+		if err != nil {
+			return "", err
+		}
+		return secretName, nil
+	}
+
+	if secretName != "" {
+		return "", errors.New("image pull secret and docker config cannot both be set")
+	}
+	secretName = defaultPullSecretName
+	b, err := os.ReadFile(dockerConfigPath)
+	if err != nil {
+		return "", err
+	}
+	if !json.Valid(b) {
+		return "", fmt.Errorf("docker config %s is not valid JSON", dockerConfigPath)
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   secretName,
+			Labels: w.labels(secretName),
+		},
+		Type: corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: b,
+		},
+	}
+	_, err = cs.CoreV1().Secrets(namespace).Create(ctx, secret, metav1.CreateOptions{})
+	if kerrors.IsAlreadyExists(err) {
+		_, err = cs.CoreV1().Secrets(namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return "", err
+	}
+	return secretName, nil
+}
+
+// registryKeychain uses the docker config credentials, falling back to the default keychain.
+type registryKeychain struct {
+	auths map[string]authn.AuthConfig
+}
+
+// newRegistryKeychain reads the credentials from the docker config or otherwise from the pull secret.
+func newRegistryKeychain(ctx context.Context, cs kubernetes.Interface, namespace, secretName, dockerConfigPath string) (registryKeychain, error) {
+	var b []byte
+	switch {
+	case dockerConfigPath != "":
+		var err error
+		b, err = os.ReadFile(dockerConfigPath)
+		if err != nil {
+			return registryKeychain{}, err
+		}
+	case secretName != "":
+		secret, err := cs.CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+		if err != nil {
+			return registryKeychain{}, err
+		}
+		b = secret.Data[corev1.DockerConfigJsonKey]
+		if b == nil {
+			return registryKeychain{}, fmt.Errorf("image pull secret %s does not contain %s", secretName, corev1.DockerConfigJsonKey)
+		}
+	default:
+		return registryKeychain{}, nil
+	}
+	cfg := struct {
+		Auths map[string]authn.AuthConfig `json:"auths"`
+	}{}
+	err := json.Unmarshal(b, &cfg)
+	if err != nil {
+		return registryKeychain{}, fmt.Errorf("could not parse docker config: %w", err)
+	}
+	auths := map[string]authn.AuthConfig{}
+	for server, auth := range cfg.Auths {
+		auths[registryHost(server)] = auth
+	}
+	return registryKeychain{auths: auths}, nil
+}
+
+func (k registryKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) { //nolint:ireturn // Resolve implements authn.Keychain.
+	auth, ok := k.auths[registryHost(target.RegistryStr())]
+	if !ok {
+		return authn.DefaultKeychain.Resolve(target)
+	}
+	return authn.FromConfig(auth), nil
+}
+
+// registryHost normalizes a docker config server to the registry host of image references.
+func registryHost(server string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	host, _, _ = strings.Cut(host, "/")
+	if host == "docker.io" || host == "index.docker.io" {
+		return name.DefaultRegistry
+	}
+	return host
+}
@@ -0,0 +1,146 @@
+package measure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-containerregistry/pkg/authn"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/cli-utils/pkg/kstatus/status"
+)
+
+// Scenario deploys a workload running an image and measures the image pulls caused by it.
+type Scenario interface {
+	// Name identifies the scenario in benchmark results.
+	Name() string
+	// Measure deploys or updates the workload to run the image and returns the pull samples.
+	Measure(ctx context.Context, cs kubernetes.Interface, dc dynamic.Interface, w Workload, image string) ([]Sample, error)
+	// Cleanup removes the workload created by Measure.
+	Cleanup(ctx context.Context, cs kubernetes.Interface, w Workload) error
+}
+
+// Workload identifies the workload deployed by a scenario within a benchmark run.
+type Workload struct {
+	Cleaner          ImageCleaner
+	Keychain         authn.Keychain
+	GarbageCollector *GarbageCollector
+	Namespace        string
+	Name             string
+	RunID            string
+	ImagePullSecrets []corev1.LocalObjectReference
+}
+
+// labels returns the labels set on every object created for the workload, which are used to clean up leftovers.
+func (w Workload) labels(app string) map[string]string {
+	return map[string]string{
+		"app":          app,
+		managedByLabel: managedByValue,
+		runIDLabel:     w.RunID,
+	}
+}
+
+var (
+	daemonSetGVR  = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "daemonsets"}
+	deploymentGVR = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+)
+
+func waitForCurrent(ctx context.Context, dc dynamic.Interface, gvr schema.GroupVersionResource, namespace, name string, timeout time.Duration) error {
+	log := logr.FromContextOrDiscard(ctx)
+	return wait.PollUntilContextTimeout(ctx, 1*time.Second, timeout, true, func(ctx context.Context) (done bool, err error) {
+		u, err := dc.Resource(gvr).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		res, err := status.Compute(u)
+		if err != nil {
+			return false, err
+		}
+		if res.Status == status.FailedStatus {
+			return false, fmt.Errorf("%s failed: %s", name, res.Message)
+		}
+		if res.Status != status.CurrentStatus {
+			log.Info("waiting for rollout", "name", name, "message", res.Message)
+			return false, nil
+		}
+		return true, nil
+	})
+}
+
+// waitForPodsDeleted waits until no pods matching the selector remain.
+func waitForPodsDeleted(ctx context.Context, cs kubernetes.Interface, namespace, selector string) error {
+	return wait.PollUntilContextTimeout(ctx, 1*time.Second, 5*time.Minute, true, func(ctx context.Context) (done bool, err error) {
+		podList, err := cs.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return false, err
+		}
+		return len(podList.Items) == 0, nil
+	})
+}
+
+// collectSamples returns the pull duration of each pod. Pods which found the image already present are skipped when
+// skipPresent is set, for workloads where several pods share a node, and fail the measurement otherwise.
+func collectSamples(ctx context.Context, cs kubernetes.Interface, namespace, selector string, skipPresent bool) ([]Sample, error) {
+	log := logr.FromContextOrDiscard(ctx)
+	log.Info("collecting image pull durations")
+	podList, err := cs.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	if len(podList.Items) == 0 {
+		return nil, errors.New("received empty benchmark pod list")
+	}
+	samples := []Sample{}
+	for _, pod := range podList.Items {
+		eventList, err := cs.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{FieldSelector: fmt.Sprintf("involvedObject.name=%s", pod.Name), TypeMeta: metav1.TypeMeta{Kind: "Pod"}})
+		if err != nil {
+			return nil, err
+		}
+		pulledEvent, err := getEvent(eventList.Items, "Pulled")
+		if err != nil {
+			return nil, err
+		}
+		if isAlreadyPresent(pulledEvent.Message) {
+			if !skipPresent {
+				return nil, fmt.Errorf("image was already present on node %s for pod %s", pod.Spec.NodeName, pod.Name)
+			}
+			log.Info("skipping pod with image already present", "pod", pod.Name, "node", pod.Spec.NodeName)
+			continue
+		}
+		pullingEvent, err := getEvent(eventList.Items, "Pulling")
+		if err != nil {
+			return nil, err
+		}
+		d, err := parsePullMessage(pulledEvent.Message)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, Sample{Node: pod.Spec.NodeName, Start: pullingEvent.FirstTimestamp.Time, Stop: pullingEvent.FirstTimestamp.Add(d), Duration: d})
+	}
+	if len(samples) == 0 {
+		return nil, errors.New("no benchmark pod pulled the image")
+	}
+	return samples, nil
+}
+
+func isAlreadyPresent(msg string) bool {
+	return strings.Contains(msg, "already present on machine")
+}
+
+func benchmarkContainer(image string) corev1.Container {
+	return corev1.Container{
+		Name:            "benchmark",
+		Image:           image,
+		ImagePullPolicy: "IfNotPresent",
+		// Keep container running
+		Stdin: true,
+	}
+}
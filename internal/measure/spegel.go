@@ -0,0 +1,106 @@
+package measure
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Spegel describes the Spegel deployment found in the cluster when a suite was run.
+type Spegel struct {
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Image     string `json:"image,omitempty"`
+	Version   string `json:"version,omitempty"`
+	// Args contains the arguments of each container and init container by container name.
+	Args map[string][]string `json:"args,omitempty"`
+	// MirroredRegistries are the registries which are configured to be mirrored by Spegel.
+	MirroredRegistries []string `json:"mirroredRegistries,omitempty"`
+	// MirrorTargets are the registries used as mirrors, usually the local Spegel instance.
+	MirrorTargets []string `json:"mirrorTargets,omitempty"`
+	// Detected is false when no Spegel DaemonSet was found, so that the absence is recorded explicitly.
+	Detected bool `json:"detected"`
+}
+
+// detectSpegel finds the Spegel DaemonSet matching the label selector in any namespace.
+func detectSpegel(ctx context.Context, cs kubernetes.Interface, selector string) (Spegel, error) {
+	dsList, err := cs.AppsV1().DaemonSets(metav1.NamespaceAll).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return Spegel{}, err
+	}
+	switch len(dsList.Items) {
+	case 0:
+		return Spegel{Detected: false}, nil
+	case 1:
+		return newSpegel(dsList.Items[0]), nil
+	default:
+		names := []string{}
+		for _, ds := range dsList.Items {
+			names = append(names, fmt.Sprintf("%s/%s", ds.Namespace, ds.Name))
+		}
+		return Spegel{}, fmt.Errorf("found multiple Spegel DaemonSets %s matching selector %s", strings.Join(names, ", "), selector)
+	}
+}
+
+func newSpegel(ds appsv1.DaemonSet) Spegel {
+	spegel := Spegel{
+		Namespace: ds.Namespace,
+		Name:      ds.Name,
+		Args:      map[string][]string{},
+		Detected:  true,
+	}
+	containers := []corev1.Container{}
+	containers = append(containers, ds.Spec.Template.Spec.InitContainers...)
+	containers = append(containers, ds.Spec.Template.Spec.Containers...)
+	for _, container := range containers {
+		spegel.Args[container.Name] = container.Args
+		if spegel.Image == "" && strings.Contains(container.Image, "spegel") {
+			spegel.Image = container.Image
+			spegel.Version = imageVersion(container.Image)
+		}
+		if len(spegel.MirroredRegistries) == 0 {
+			spegel.MirroredRegistries = argValues(container.Args, "--mirrored-registries")
+		}
+		// Newer versions of Spegel renamed the mirror registries flag to mirror targets.
+		for _, flag := range []string{"--mirror-targets", "--mirror-registries"} {
+			if len(spegel.MirrorTargets) == 0 {
+				spegel.MirrorTargets = argValues(container.Args, flag)
+			}
+		}
+	}
+	return spegel
+}
+
+// imageVersion returns the tag of the image reference, ignoring any digest.
+func imageVersion(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	i := strings.LastIndex(image, ":")
+	if i == -1 || strings.Contains(image[i:], "/") {
+		return ""
+	}
+	return image[i+1:]
+}
+
+// argValues returns the values of a flag given either as --flag=value or as --flag followed by values.
+func argValues(args []string, flag string) []string {
+	values := []string{}
+	for i := 0; i < len(args); i++ {
+		if v, ok := strings.CutPrefix(args[i], flag+"="); ok {
+			values = append(values, strings.Split(v, ",")...)
+			continue
+		}
+		if args[i] != flag {
+			continue
+		}
+		for i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+			i++
+			values = append(values, strings.Split(args[i], ",")...)
+		}
+	}
+	return values
+}
@@ -0,0 +1,138 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+const (
+	// StatsPath is the path of the endpoint serving the registry stats.
+	StatsPath = "/stats"
+	// refNameAnnotation is the annotation of an OCI layout which holds the name of the image.
+	refNameAnnotation = "org.opencontainers.image.ref.name"
+)
+
+// Run serves the images in the OCI layout until the context is cancelled, counting the pull requests served.
+// Pull requests are degraded according to the shaping options.
+func Run(ctx context.Context, addr, layoutPath string, shapingOpts ShapingOptions) error {
+	regHandler := newRegistryHandler(layoutPath)
+	names, err := loadLayout(ctx, layoutPath, regHandler)
+	if err != nil {
+		return err
+	}
+
+	s := newShaper(shapingOpts)
+	srv := &http.Server{
+		Handler:           newHandler(regHandler, s),
+		ConnContext:       s.ConnContext,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(lis)
+	}()
+	logr.FromContextOrDiscard(ctx).Info("serving images", "addr", lis.Addr().String(), "images", names)
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	}
+}
+
+// newRegistryHandler serves the blobs directly from the layout.
+func newRegistryHandler(layoutPath string) http.Handler {
+	// Requests are not logged by the registry as every layer pulled by every node would be logged.
+	return registry.New(
+		registry.Logger(log.New(io.Discard, "", 0)),
+		registry.WithBlobHandler(registry.NewDiskBlobHandler(filepath.Join(layoutPath, "blobs"))),
+	)
+}
+
+func newHandler(regHandler http.Handler, s *shaper) http.Handler {
+	recorder := newStatsRecorder()
+	mux := http.NewServeMux()
+	mux.Handle(StatsPath, recorder)
+	// Requests rejected by the shaper are not counted as they were not served.
+	mux.Handle("/", s.Handler(recorder.Handler(regHandler)))
+	return mux
+}
+
+// loadLayout pushes the manifests of all named images in the layout to the registry handler. The handler is served on a
+// temporary loopback listener so that shaping and stats do not apply to the push.
+func loadLayout(ctx context.Context, layoutPath string, regHandler http.Handler) ([]string, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{
+		Handler:           regHandler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		//nolint: errcheck // Serve always returns an error once the server is closed.
+		srv.Serve(lis)
+	}()
+	defer srv.Close()
+	registryAddr := lis.Addr().String()
+
+	p, err := layout.FromPath(layoutPath)
+	if err != nil {
+		return nil, err
+	}
+	ii, err := p.ImageIndex()
+	if err != nil {
+		return nil, err
+	}
+	im, err := ii.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, desc := range im.Manifests {
+		imgName, ok := desc.Annotations[refNameAnnotation]
+		if !ok {
+			continue
+		}
+		ref, err := name.ParseReference(imgName)
+		if err != nil {
+			return nil, err
+		}
+		tag, err := name.NewTag(fmt.Sprintf("%s/%s:%s", registryAddr, ref.Context().RepositoryStr(), ref.Identifier()), name.Insecure)
+		if err != nil {
+			return nil, err
+		}
+		img, err := p.Image(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		err = remote.Put(tag, img, remote.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, fmt.Sprintf("%s:%s", ref.Context().RepositoryStr(), ref.Identifier()))
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no named images found in layout %s", layoutPath)
+	}
+	return names, nil
+}
@@ -0,0 +1,230 @@
+package registry
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+
+	layoutPath := t.TempDir()
+	p, err := layout.Write(layoutPath, empty.Index)
+	require.NoError(t, err)
+	img, err := random.Image(1024, 2)
+	require.NoError(t, err)
+	err = p.AppendImage(img, layout.WithAnnotations(map[string]string{refNameAnnotation: "ghcr.io/spegel-org/benchmark:v1-10MB-1"}))
+	require.NoError(t, err)
+
+	regHandler := newRegistryHandler(layoutPath)
+	names, err := loadLayout(t.Context(), layoutPath, regHandler)
+	require.NoError(t, err)
+	require.Equal(t, []string{"spegel-org/benchmark:v1-10MB-1"}, names)
+
+	// Every pull fails when shaped, but loading the layout is not shaped.
+	failing := httptest.NewServer(newHandler(regHandler, newShaper(ShapingOptions{ErrorRate: 1})))
+	t.Cleanup(failing.Close)
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, failing.URL+"/v2/spegel-org/benchmark/manifests/v1-10MB-1", http.NoBody)
+	require.NoError(t, err)
+	resp, err := failing.Client().Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	srv := httptest.NewServer(newHandler(regHandler, newShaper(ShapingOptions{})))
+	t.Cleanup(srv.Close)
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	stats, err := FetchStats(t.Context(), srv.URL+StatsPath)
+	require.NoError(t, err)
+	require.Empty(t, stats.Manifests)
+
+	ref, err := name.ParseReference(addr+"/spegel-org/benchmark:v1-10MB-1", name.Insecure)
+	require.NoError(t, err)
+	pulled, err := remote.Image(ref, remote.WithContext(t.Context()))
+	require.NoError(t, err)
+	layers, err := pulled.Layers()
+	require.NoError(t, err)
+	layerBytes := int64(0)
+	for _, layer := range layers {
+		rc, err := layer.Compressed()
+		require.NoError(t, err)
+		n, err := io.Copy(io.Discard, rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		layerBytes += n
+	}
+
+	stats, err = FetchStats(t.Context(), srv.URL+StatsPath)
+	require.NoError(t, err)
+	digest, err := layers[0].Digest()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), stats.Blobs[digest.String()].Requests)
+	size, err := layers[0].Size()
+	require.NoError(t, err)
+	require.Equal(t, size, stats.Blobs[digest.String()].Bytes)
+	require.Contains(t, stats.Manifests, "spegel-org/benchmark:v1-10MB-1")
+	require.Len(t, stats.Clients, 1)
+	require.Contains(t, stats.Clients, "127.0.0.1")
+	blobBytes := int64(0)
+	for _, c := range stats.Blobs {
+		blobBytes += c.Bytes
+	}
+	require.Equal(t, layerBytes, blobBytes)
+}
+
+func TestStatsSub(t *testing.T) {
+	t.Parallel()
+
+	earlier := Stats{
+		Blobs:     map[string]Counter{"sha256:a": {Requests: 1, Bytes: 10}},
+		Manifests: map[string]Counter{},
+		Clients:   map[string]Counter{"10.0.0.1": {Requests: 1, Bytes: 10}},
+		Total:     Counter{Requests: 1, Bytes: 10},
+	}
+	now := Stats{
+		Blobs:     map[string]Counter{"sha256:a": {Requests: 1, Bytes: 10}, "sha256:b": {Requests: 2, Bytes: 40}},
+		Manifests: map[string]Counter{"foo:bar": {Requests: 1, Bytes: 5}},
+		Clients:   map[string]Counter{"10.0.0.1": {Requests: 2, Bytes: 30}, "10.0.0.2": {Requests: 2, Bytes: 25}},
+		Total:     Counter{Requests: 5, Bytes: 65},
+	}
+	expected := Stats{
+		Blobs:     map[string]Counter{"sha256:b": {Requests: 2, Bytes: 40}},
+		Manifests: map[string]Counter{"foo:bar": {Requests: 1, Bytes: 5}},
+		Clients:   map[string]Counter{"10.0.0.1": {Requests: 1, Bytes: 20}, "10.0.0.2": {Requests: 2, Bytes: 25}},
+		Total:     Counter{Requests: 4, Bytes: 55},
+	}
+	require.Equal(t, expected, now.Sub(earlier))
+}
+
+func TestPullRequest(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		method       string
+		path         string
+		expectedKind string
+		expectedKey  string
+		expectedOk   bool
+	}{
+		{method: http.MethodGet, path: "/v2/spegel-org/benchmark/blobs/sha256:abc", expectedKind: "blobs", expectedKey: "sha256:abc", expectedOk: true},
+		{method: http.MethodHead, path: "/v2/spegel-org/benchmark/manifests/v1", expectedKind: "manifests", expectedKey: "spegel-org/benchmark:v1", expectedOk: true},
+		{method: http.MethodGet, path: "/v2/spegel-org/benchmark/manifests/sha256:abc", expectedKind: "manifests", expectedKey: "spegel-org/benchmark@sha256:abc", expectedOk: true},
+		{method: http.MethodPut, path: "/v2/spegel-org/benchmark/manifests/v1"},
+		{method: http.MethodGet, path: "/v2/"},
+		{method: http.MethodGet, path: "/stats"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(tt.method, tt.path, http.NoBody)
+			kind, key, ok := pullRequest(req)
+			require.Equal(t, tt.expectedOk, ok)
+			require.Equal(t, tt.expectedKind, kind)
+			require.Equal(t, tt.expectedKey, key)
+		})
+	}
+}
+
+func TestShaper(t *testing.T) {
+	t.Parallel()
+
+	body := bytes.Repeat([]byte("a"), 4096)
+	next := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		//nolint: errcheck // Ignore error.
+		w.Write(body)
+	})
+	blobPath := "/v2/spegel-org/benchmark/blobs/sha256:abc"
+
+	tests := []struct {
+		name           string
+		opts           ShapingOptions
+		path           string
+		requests       int
+		expectedStatus int
+		minDuration    time.Duration
+	}{
+		{
+			name:           "no shaping",
+			path:           blobPath,
+			requests:       1,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "latency",
+			opts:           ShapingOptions{Latency: 50 * time.Millisecond},
+			path:           blobPath,
+			requests:       1,
+			expectedStatus: http.StatusOK,
+			minDuration:    50 * time.Millisecond,
+		},
+		{
+			name:           "bandwidth",
+			opts:           ShapingOptions{Bandwidth: 8192},
+			path:           blobPath,
+			requests:       3,
+			expectedStatus: http.StatusOK,
+			// The first 8192 bytes are allowed as burst, the remaining 4096 bytes take half a second.
+			minDuration: 400 * time.Millisecond,
+		},
+		{
+			name:           "error rate",
+			opts:           ShapingOptions{ErrorRate: 1},
+			path:           blobPath,
+			requests:       1,
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:           "rate limit",
+			opts:           ShapingOptions{RateLimit: 0.001, RateLimitBurst: 2},
+			path:           blobPath,
+			requests:       3,
+			expectedStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:           "ignores push requests",
+			opts:           ShapingOptions{ErrorRate: 1},
+			path:           "/v2/",
+			requests:       1,
+			expectedStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := newShaper(tt.opts)
+			srv := httptest.NewUnstartedServer(s.Handler(next))
+			srv.Config.ConnContext = s.ConnContext
+			srv.Start()
+			t.Cleanup(srv.Close)
+
+			start := time.Now()
+			status := 0
+			for range tt.requests {
+				req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, srv.URL+tt.path, http.NoBody)
+				require.NoError(t, err)
+				resp, err := srv.Client().Do(req)
+				require.NoError(t, err)
+				_, err = io.Copy(io.Discard, resp.Body)
+				require.NoError(t, err)
+				require.NoError(t, resp.Body.Close())
+				status = resp.StatusCode
+			}
+			require.Equal(t, tt.expectedStatus, status)
+			require.GreaterOrEqual(t, time.Since(start), tt.minDuration)
+		})
+	}
+}
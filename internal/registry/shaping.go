@@ -0,0 +1,119 @@
+package registry
+
+import (
+	"context"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ShapingOptions degrade the registry to reproduce slow or throttled upstream registries. Only pull requests are shaped.
+type ShapingOptions struct {
+	// Latency is added before responding to each request.
+	Latency time.Duration
+	// Bandwidth is the amount of bytes per second each connection is limited to, zero disables the limit.
+	Bandwidth int64
+	// ErrorRate is the probability between zero and one of responding with an internal server error.
+	ErrorRate float64
+	// RateLimit is the amount of requests per second allowed before responding with too many requests, zero disables the limit.
+	RateLimit float64
+	// RateLimitBurst is the amount of requests allowed to exceed the rate limit at once.
+	RateLimitBurst int
+}
+
+// maxBandwidthChunk is the largest write done at once when the bandwidth is limited.
+const maxBandwidthChunk = 32 * 1024
+
+type connLimiterKey struct{}
+
+type shaper struct {
+	limiter *rate.Limiter
+	opts    ShapingOptions
+}
+
+func newShaper(opts ShapingOptions) *shaper {
+	s := &shaper{
+		opts: opts,
+	}
+	if opts.RateLimit > 0 {
+		s.limiter = rate.NewLimiter(rate.Limit(opts.RateLimit), max(opts.RateLimitBurst, 1))
+	}
+	return s
+}
+
+// ConnContext adds a bandwidth limiter for each connection, so that the limit applies per connection rather than per request.
+func (s *shaper) ConnContext(ctx context.Context, _ net.Conn) context.Context {
+	if s.opts.Bandwidth <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, connLimiterKey{}, s.bandwidthLimiter())
+}
+
+func (s *shaper) bandwidthLimiter() *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(s.opts.Bandwidth), int(min(s.opts.Bandwidth, maxBandwidthChunk)))
+}
+
+// Handler applies the shaping options to pull requests served by the next handler.
+func (s *shaper) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, ok := pullRequest(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if s.limiter != nil && !s.limiter.Allow() {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		if s.opts.Latency > 0 {
+			timer := time.NewTimer(s.opts.Latency)
+			select {
+			case <-r.Context().Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+		if s.opts.ErrorRate > 0 && rand.Float64() < s.opts.ErrorRate {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if s.opts.Bandwidth <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		limiter, ok := r.Context().Value(connLimiterKey{}).(*rate.Limiter)
+		if !ok {
+			limiter = s.bandwidthLimiter()
+		}
+		next.ServeHTTP(&limitedWriter{ResponseWriter: w, ctx: r.Context(), limiter: limiter}, r)
+	})
+}
+
+type limitedWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func (w *limitedWriter) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		chunk := min(len(b)-written, w.limiter.Burst())
+		err := w.limiter.WaitN(w.ctx, chunk)
+		if err != nil {
+			return written, err
+		}
+		n, err := w.ResponseWriter.Write(b[written : written+chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,187 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Counter is the amount of requests and response body bytes served.
+type Counter struct {
+	Requests int64 `json:"requests"`
+	Bytes    int64 `json:"bytes"`
+}
+
+func (c Counter) sub(other Counter) Counter {
+	return Counter{
+		Requests: c.Requests - other.Requests,
+		Bytes:    c.Bytes - other.Bytes,
+	}
+}
+
+// Stats are the pull requests served by the registry. Blobs are keyed by digest and clients by remote IP.
+type Stats struct {
+	Blobs     map[string]Counter `json:"blobs"`
+	Manifests map[string]Counter `json:"manifests"`
+	Clients   map[string]Counter `json:"clients"`
+	Total     Counter            `json:"total"`
+}
+
+func newStats() Stats {
+	return Stats{
+		Blobs:     map[string]Counter{},
+		Manifests: map[string]Counter{},
+		Clients:   map[string]Counter{},
+	}
+}
+
+// Sub returns the requests served since the earlier stats were fetched. Entries without any new requests are omitted.
+func (s Stats) Sub(earlier Stats) Stats {
+	diff := newStats()
+	for _, m := range []struct {
+		now     map[string]Counter
+		earlier map[string]Counter
+		diff    map[string]Counter
+	}{
+		{now: s.Blobs, earlier: earlier.Blobs, diff: diff.Blobs},
+		{now: s.Manifests, earlier: earlier.Manifests, diff: diff.Manifests},
+		{now: s.Clients, earlier: earlier.Clients, diff: diff.Clients},
+	} {
+		for k, v := range m.now {
+			c := v.sub(m.earlier[k])
+			if c.Requests == 0 {
+				continue
+			}
+			m.diff[k] = c
+		}
+	}
+	diff.Total = s.Total.sub(earlier.Total)
+	return diff
+}
+
+// FetchStats gets the current stats from the registry stats endpoint.
+func FetchStats(ctx context.Context, url string) (Stats, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Stats{}, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Stats{}, fmt.Errorf("unexpected status code %d when fetching registry stats", resp.StatusCode)
+	}
+	stats := Stats{}
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	if err != nil {
+		return Stats{}, err
+	}
+	return stats, nil
+}
+
+type statsRecorder struct {
+	stats Stats
+	mx    sync.Mutex
+}
+
+func newStatsRecorder() *statsRecorder {
+	return &statsRecorder{
+		stats: newStats(),
+	}
+}
+
+// Handler counts the pull requests served by the next handler.
+func (s *statsRecorder) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kind, key, ok := pullRequest(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &countingWriter{ResponseWriter: w}
+		next.ServeHTTP(cw, r)
+
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		c := Counter{Requests: 1, Bytes: cw.n}
+		s.mx.Lock()
+		defer s.mx.Unlock()
+		switch kind {
+		case "blobs":
+			s.stats.Blobs[key] = add(s.stats.Blobs[key], c)
+		case "manifests":
+			s.stats.Manifests[key] = add(s.stats.Manifests[key], c)
+		}
+		s.stats.Clients[client] = add(s.stats.Clients[client], c)
+		s.stats.Total = add(s.stats.Total, c)
+	})
+}
+
+// ServeHTTP writes the current stats as JSON.
+func (s *statsRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mx.Lock()
+	b, err := json.Marshal(s.stats)
+	s.mx.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	//nolint: errcheck // Nothing can be done if the client goes away.
+	w.Write(b)
+}
+
+func add(a, b Counter) Counter {
+	return Counter{
+		Requests: a.Requests + b.Requests,
+		Bytes:    a.Bytes + b.Bytes,
+	}
+}
+
+// pullRequest returns if the request is a blob or manifest pull, with the digest or repository and reference as key.
+func pullRequest(r *http.Request) (string, string, bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return "", "", false
+	}
+	p, ok := strings.CutPrefix(r.URL.Path, "/v2/")
+	if !ok {
+		return "", "", false
+	}
+	elems := strings.Split(p, "/")
+	if len(elems) < 3 {
+		return "", "", false
+	}
+	kind := elems[len(elems)-2]
+	ref := elems[len(elems)-1]
+	repo := strings.Join(elems[:len(elems)-2], "/")
+	switch kind {
+	case "blobs":
+		return kind, ref, true
+	case "manifests":
+		if strings.Contains(ref, ":") {
+			return kind, fmt.Sprintf("%s@%s", repo, ref), true
+		}
+		return kind, fmt.Sprintf("%s:%s", repo, ref), true
+	default:
+		return "", "", false
+	}
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
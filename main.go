@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/alexflint/go-arg"
 	"github.com/c2h5oh/datasize"
@@ -16,38 +17,131 @@ import (
 	"github.com/spegel-org/benchmark/internal/analyze"
 	"github.com/spegel-org/benchmark/internal/generate"
 	"github.com/spegel-org/benchmark/internal/measure"
+	"github.com/spegel-org/benchmark/internal/registry"
 )
 
 type GenerateCmd struct {
 	ImageName  string            `arg:"--image-name,required"`
 	LayerCount int               `arg:"--layer-count,required"`
 	ImageSize  datasize.ByteSize `arg:"--image-size,required"`
+	LayoutPath string            `arg:"--layout-path" help:"Write the image to an OCI layout instead of the Docker daemon."`
+}
+
+type ScenarioArgs struct {
+	TopologyKey   string   `arg:"--topology-key" default:"kubernetes.io/hostname" help:"Node label used to spread deployment replicas."`
+	Replicas      int32    `arg:"--replicas" default:"10" help:"Replica count the deployment scenario scales to."`
+	MaxSkew       int32    `arg:"--max-skew" default:"0" help:"Max skew of the deployment topology spread constraint, zero disables it."`
+	ColdNodes     []string `arg:"--cold-nodes" help:"Nodes to clear images from in the new-node scenario."`
+	ColdNodeCount int      `arg:"--cold-node-count" default:"1" help:"Amount of nodes to clear images from in the new-node scenario when no cold nodes are given."`
+	Parallelism   int32    `arg:"--parallelism" default:"10" help:"Amount of pods running at the same time in the job scenario."`
+	Completions   int32    `arg:"--completions" default:"20" help:"Amount of pods that have to complete in the job scenario."`
+}
+
+type CleanerArgs struct {
+	ImageCleaner        string `arg:"--image-cleaner" default:"crictl" help:"Method used to remove images from nodes, one of crictl, ctr or none."`
+	ContainerdNamespace string `arg:"--containerd-namespace" default:"k8s.io" help:"Containerd namespace used by ctr on the nodes."`
+	ContainerdRoot      string `arg:"--containerd-root" default:"/var/lib/containerd" help:"Containerd root directory on the nodes."`
+	GarbageCollect      bool   `arg:"--gc" help:"Trigger containerd garbage collection of content and snapshots after removing images."`
+}
+
+type RegistryArgs struct {
+	ImagePullSecret  string `arg:"--image-pull-secret" help:"Name of an existing image pull secret in the namespace to use for benchmark pods."`
+	DockerConfigPath string `arg:"--docker-config" help:"Path to a docker config.json used to create the image pull secret for benchmark pods, cannot be combined with --image-pull-secret."`
+	RegistryStatsURL string `arg:"--registry-stats-url" help:"Stats endpoint of the built-in registry used to record the requests served by the upstream registry."`
+}
+
+type ChaosArgs struct {
+	ChaosAfter                 time.Duration  `arg:"--chaos-after" default:"10s" help:"Time after the update measurement starts when faults are injected."`
+	ChaosDeleteSpegelPods      int            `arg:"--chaos-delete-spegel-pods" help:"Percentage of Spegel pods to delete during the update measurement."`
+	ChaosDeleteSpegelPodsAfter *time.Duration `arg:"--chaos-delete-spegel-pods-after" help:"Time after the update measurement starts when Spegel pods are deleted, defaults to --chaos-after."`
+	ChaosDrainNode             string         `arg:"--chaos-drain-node" help:"Node to cordon and drain during the update measurement, the Spegel pod on the node is deleted."`
+	ChaosDrainNodeAfter        *time.Duration `arg:"--chaos-drain-node-after" help:"Time after the update measurement starts when the node is drained, defaults to --chaos-after."`
 }
 
 type MeasureCmd struct {
 	OutputDir      string   `arg:"--output-dir,required"`
 	KubeconfigPath string   `arg:"--kubeconfig,env:KUBECONFIG"`
 	Namespace      string   `arg:"--namespace" default:"spegel-benchmark"`
+	Scenario       string   `arg:"--scenario" default:"daemonset" help:"Scenario to measure, one of daemonset, deployment, new-node or job."`
 	Images         []string `arg:"--images,required"`
+	SpegelSelector string   `arg:"--spegel-selector" default:"app.kubernetes.io/name=spegel" help:"Label selector used to find the Spegel pods to scrape metrics from."`
+	NodeMetrics    bool     `arg:"--node-metrics" help:"Scrape the cAdvisor metrics of all nodes through the API server, such as the network bytes received."`
+	ScenarioArgs
+	CleanerArgs
+	RegistryArgs
+	ChaosArgs
 }
 
 type SuiteCmd struct {
-	OutputDir      string `arg:"--output-dir,required"`
+	OutputDir      string   `arg:"--output-dir,required"`
+	KubeconfigPath string   `arg:"--kubeconfig,env:KUBECONFIG"`
+	Namespace      string   `arg:"--namespace" default:"spegel-benchmark"`
+	Name           string   `arg:"--name,required"`
+	Scenarios      []string `arg:"--scenarios" help:"Scenarios to run for each benchmark image, defaults to daemonset."`
+	SpegelSelector string   `arg:"--spegel-selector" default:"app.kubernetes.io/name=spegel" help:"Label selector used to detect the Spegel DaemonSet and scrape metrics from its pods."`
+	NodeMetrics    bool     `arg:"--node-metrics" help:"Scrape the cAdvisor metrics of all nodes through the API server, such as the network bytes received."`
+	ScenarioArgs
+	CleanerArgs
+	RegistryArgs
+	ChaosArgs
+}
+
+type CleanupCmd struct {
 	KubeconfigPath string `arg:"--kubeconfig,env:KUBECONFIG"`
 	Namespace      string `arg:"--namespace" default:"spegel-benchmark"`
-	Name           string `arg:"--name,required"`
+	RunID          string `arg:"--run-id" help:"Only remove resources from this run, keeping the namespace."`
+}
+
+type PreflightCmd struct {
+	KubeconfigPath string   `arg:"--kubeconfig,env:KUBECONFIG"`
+	Namespace      string   `arg:"--namespace" default:"spegel-benchmark"`
+	Images         []string `arg:"--images" help:"Images to resolve, defaults to the suite images."`
+	SpegelSelector string   `arg:"--spegel-selector" default:"app.kubernetes.io/name=spegel" help:"Label selector used to detect the Spegel DaemonSet and scrape metrics from its pods."`
+	NodeMetrics    bool     `arg:"--node-metrics" help:"Scrape the cAdvisor metrics of all nodes through the API server, such as the network bytes received."`
+	CleanerArgs
+	RegistryArgs
+	ChaosArgs
+}
+
+type RegistryCmd struct {
+	Addr           string            `arg:"--addr" default:":5000" help:"Address to serve the registry on."`
+	LayoutPath     string            `arg:"--layout-path,required" help:"OCI layout containing the images to serve."`
+	Latency        time.Duration     `arg:"--latency" help:"Latency added to each pull request."`
+	Bandwidth      datasize.ByteSize `arg:"--bandwidth" help:"Bandwidth per second each connection is limited to, zero disables the limit."`
+	ErrorRate      float64           `arg:"--error-rate" help:"Probability between zero and one of failing a pull request."`
+	RateLimit      float64           `arg:"--rate-limit" help:"Pull requests per second allowed before responding with 429, zero disables the limit."`
+	RateLimitBurst int               `arg:"--rate-limit-burst" default:"10" help:"Pull requests allowed to exceed the rate limit at once."`
+}
+
+type CompareCmd struct {
+	BaselinePath  string   `arg:"--baseline,required" help:"Path to the baseline suite."`
+	CandidatePath string   `arg:"--candidate,required" help:"Path to the candidate suite."`
+	Thresholds    []string `arg:"--thresholds" help:"Maximum increase in percent as phase.statistic=percent, defaults to create.p95=10 and update.p95=10."`
+}
+
+type ExportCmd struct {
+	SuitePaths []string `arg:"--suite-paths,required" help:"Paths to the suites to export."`
+	Format     string   `arg:"--format" default:"csv" help:"Export format, either csv or parquet."`
+	OutputPath string   `arg:"--output" help:"File to write the export to, defaults to stdout."`
 }
 
 type AnalyzeCmd struct {
-	OutputDir  string   `arg:"--output-dir,required"`
-	SuitePaths []string `arg:"--suite-paths,required"`
+	Compare    *CompareCmd `arg:"subcommand:compare" help:"Compare a candidate suite to a baseline suite and fail on regressions."`
+	Export     *ExportCmd  `arg:"subcommand:export" help:"Export the samples of suites as one row per sample."`
+	OutputDir  string      `arg:"--output-dir"`
+	SuitePaths []string    `arg:"--suite-paths"`
+	Benchmarks string      `arg:"--benchmarks" default:"union" help:"Benchmarks to compare, either the union or intersection of the benchmarks in each suite."`
+	ImageSizes string      `arg:"--image-sizes" default:"key" help:"Source of the image sizes used to compute throughput, either the nominal size in the benchmark key or the compressed size from the registry."`
 }
 
 type Arguments struct {
-	Generate *GenerateCmd `arg:"subcommand:generate" help:"Generate images for benchmarking."`
-	Measure  *MeasureCmd  `arg:"subcommand:measure" help:"Run benchmark measurement."`
-	Suite    *SuiteCmd    `arg:"subcommand:suite" help:"Run the full suite of measurements."`
-	Analyze  *AnalyzeCmd  `arg:"subcommand:analyze" help:"Analyze benchmark results."`
+	Generate  *GenerateCmd  `arg:"subcommand:generate" help:"Generate images for benchmarking."`
+	Measure   *MeasureCmd   `arg:"subcommand:measure" help:"Run benchmark measurement."`
+	Suite     *SuiteCmd     `arg:"subcommand:suite" help:"Run the full suite of measurements."`
+	Analyze   *AnalyzeCmd   `arg:"subcommand:analyze" help:"Analyze benchmark results."`
+	Cleanup   *CleanupCmd   `arg:"subcommand:cleanup" help:"Remove resources left behind by previous benchmark runs."`
+	Preflight *PreflightCmd `arg:"subcommand:preflight" help:"Check that the cluster is able to run benchmarks."`
+	Registry  *RegistryCmd  `arg:"subcommand:registry" help:"Serve generated images as an instrumented upstream registry."`
 }
 
 func main() {
@@ -74,20 +168,210 @@ func run(args Arguments) error {
 
 	switch {
 	case args.Generate != nil:
-		return generate.Generate(ctx, args.Generate.ImageName, args.Generate.LayerCount, args.Generate.ImageSize)
+		return generate.Generate(ctx, args.Generate.ImageName, args.Generate.LayerCount, args.Generate.ImageSize, args.Generate.LayoutPath)
 	case args.Measure != nil:
 		if args.Measure.KubeconfigPath == "" {
 			return errors.New("kubeconfig path cannot be empty")
 		}
-		return measure.RunMeasure(ctx, args.Measure.KubeconfigPath, args.Measure.Namespace, args.Measure.OutputDir, args.Measure.Images)
+		scenarios, err := parseScenarios([]string{args.Measure.Scenario}, args.Measure.ScenarioArgs)
+		if err != nil {
+			return err
+		}
+		opts, err := benchmarkOptions(args.Measure.KubeconfigPath, args.Measure.Namespace, args.Measure.CleanerArgs, args.Measure.RegistryArgs)
+		if err != nil {
+			return err
+		}
+		opts.SpegelSelector = args.Measure.SpegelSelector
+		opts.NodeMetrics = args.Measure.NodeMetrics
+		opts.Chaos, err = chaosSteps(args.Measure.ChaosArgs, opts.SpegelSelector)
+		if err != nil {
+			return err
+		}
+		return measure.RunMeasure(ctx, opts, scenarios[0], args.Measure.OutputDir, args.Measure.Images)
 	case args.Suite != nil:
 		if args.Suite.KubeconfigPath == "" {
 			return errors.New("kubeconfig path cannot be empty")
 		}
-		return measure.RunSuite(ctx, args.Suite.KubeconfigPath, args.Suite.Namespace, args.Suite.OutputDir, args.Suite.Name)
+		scenarioNames := args.Suite.Scenarios
+		if len(scenarioNames) == 0 {
+			scenarioNames = []string{"daemonset"}
+		}
+		scenarios, err := parseScenarios(scenarioNames, args.Suite.ScenarioArgs)
+		if err != nil {
+			return err
+		}
+		opts, err := benchmarkOptions(args.Suite.KubeconfigPath, args.Suite.Namespace, args.Suite.CleanerArgs, args.Suite.RegistryArgs)
+		if err != nil {
+			return err
+		}
+		opts.SpegelSelector = args.Suite.SpegelSelector
+		opts.NodeMetrics = args.Suite.NodeMetrics
+		opts.Chaos, err = chaosSteps(args.Suite.ChaosArgs, opts.SpegelSelector)
+		if err != nil {
+			return err
+		}
+		return measure.RunSuite(ctx, opts, scenarios, args.Suite.OutputDir, args.Suite.Name)
+	case args.Analyze != nil && args.Analyze.Compare != nil:
+		thresholdValues := args.Analyze.Compare.Thresholds
+		if len(thresholdValues) == 0 {
+			thresholdValues = []string{"create.p95=10", "update.p95=10"}
+		}
+		thresholds := []analyze.Threshold{}
+		for _, v := range thresholdValues {
+			threshold, err := analyze.ParseThreshold(v)
+			if err != nil {
+				return err
+			}
+			thresholds = append(thresholds, threshold)
+		}
+		return analyze.Compare(args.Analyze.Compare.BaselinePath, args.Analyze.Compare.CandidatePath, thresholds, os.Stdout)
+	case args.Analyze != nil && args.Analyze.Export != nil:
+		if args.Analyze.Export.OutputPath == "" {
+			return analyze.Export(args.Analyze.Export.SuitePaths, args.Analyze.Export.Format, os.Stdout)
+		}
+		f, err := os.Create(args.Analyze.Export.OutputPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return analyze.Export(args.Analyze.Export.SuitePaths, args.Analyze.Export.Format, f)
 	case args.Analyze != nil:
-		return analyze.Analyze(ctx, args.Analyze.SuitePaths, args.Analyze.OutputDir)
+		// The analyze arguments are not required by go-arg as they would also be required by the compare subcommand.
+		if args.Analyze.OutputDir == "" || len(args.Analyze.SuitePaths) == 0 {
+			return errors.New("output dir and suite paths are required")
+		}
+		return analyze.Analyze(ctx, args.Analyze.SuitePaths, args.Analyze.OutputDir, args.Analyze.Benchmarks, args.Analyze.ImageSizes)
+	case args.Cleanup != nil:
+		if args.Cleanup.KubeconfigPath == "" {
+			return errors.New("kubeconfig path cannot be empty")
+		}
+		return measure.RunCleanup(ctx, args.Cleanup.KubeconfigPath, args.Cleanup.Namespace, args.Cleanup.RunID)
+	case args.Preflight != nil:
+		if args.Preflight.KubeconfigPath == "" {
+			return errors.New("kubeconfig path cannot be empty")
+		}
+		opts, err := benchmarkOptions(args.Preflight.KubeconfigPath, args.Preflight.Namespace, args.Preflight.CleanerArgs, args.Preflight.RegistryArgs)
+		if err != nil {
+			return err
+		}
+		opts.SpegelSelector = args.Preflight.SpegelSelector
+		opts.NodeMetrics = args.Preflight.NodeMetrics
+		opts.Chaos, err = chaosSteps(args.Preflight.ChaosArgs, opts.SpegelSelector)
+		if err != nil {
+			return err
+		}
+		return measure.RunPreflight(ctx, opts, args.Preflight.Images, os.Stdout)
+	case args.Registry != nil:
+		if args.Registry.ErrorRate < 0 || args.Registry.ErrorRate > 1 {
+			return errors.New("error rate has to be between zero and one")
+		}
+		shapingOpts := registry.ShapingOptions{
+			Latency:        args.Registry.Latency,
+			Bandwidth:      int64(args.Registry.Bandwidth.Bytes()),
+			ErrorRate:      args.Registry.ErrorRate,
+			RateLimit:      args.Registry.RateLimit,
+			RateLimitBurst: args.Registry.RateLimitBurst,
+		}
+		return registry.Run(ctx, args.Registry.Addr, args.Registry.LayoutPath, shapingOpts)
 	default:
 		return errors.New("unknown command")
 	}
 }
+
+func benchmarkOptions(kubeconfigPath, namespace string, cleanerArgs CleanerArgs, registryArgs RegistryArgs) (measure.Options, error) {
+	if registryArgs.ImagePullSecret != "" && registryArgs.DockerConfigPath != "" {
+		return measure.Options{}, errors.New("image pull secret and docker config cannot both be set")
+	}
+	opts := measure.Options{
+		KubeconfigPath:   kubeconfigPath,
+		Namespace:        namespace,
+		ImagePullSecret:  registryArgs.ImagePullSecret,
+		DockerConfigPath: registryArgs.DockerConfigPath,
+		RegistryStatsURL: registryArgs.RegistryStatsURL,
+	}
+	switch cleanerArgs.ImageCleaner {
+	case "crictl":
+		opts.ImageCleaner = measure.CrictlCleaner{}
+	case "ctr":
+		opts.ImageCleaner = measure.CtrCleaner{Namespace: cleanerArgs.ContainerdNamespace}
+	case "none":
+		opts.ImageCleaner = measure.NoopCleaner{}
+	default:
+		return measure.Options{}, fmt.Errorf("unknown image cleaner %s", cleanerArgs.ImageCleaner)
+	}
+	if cleanerArgs.GarbageCollect {
+		opts.GarbageCollector = &measure.GarbageCollector{
+			Namespace: cleanerArgs.ContainerdNamespace,
+			Root:      cleanerArgs.ContainerdRoot,
+		}
+	}
+	return opts, nil
+}
+
+func parseScenarios(names []string, args ScenarioArgs) ([]measure.Scenario, error) {
+	scenarios := []measure.Scenario{}
+	for _, name := range names {
+		switch name {
+		case "daemonset":
+			scenarios = append(scenarios, measure.DaemonSetScenario{})
+		case "deployment":
+			if args.Replicas < 1 {
+				return nil, errors.New("deployment replicas has to be at least one")
+			}
+			scenarios = append(scenarios, measure.DeploymentScenario{
+				TopologyKey: args.TopologyKey,
+				Replicas:    args.Replicas,
+				MaxSkew:     args.MaxSkew,
+			})
+		case "new-node":
+			scenarios = append(scenarios, measure.NewNodeScenario{
+				NodeNames:     args.ColdNodes,
+				ColdNodeCount: args.ColdNodeCount,
+			})
+		case "job":
+			if args.Parallelism < 1 || args.Completions < 1 {
+				return nil, errors.New("job parallelism and completions have to be at least one")
+			}
+			scenarios = append(scenarios, measure.JobScenario{
+				Parallelism: args.Parallelism,
+				Completions: args.Completions,
+			})
+		default:
+			return nil, fmt.Errorf("unknown scenario %s", name)
+		}
+	}
+	return scenarios, nil
+}
+
+func chaosSteps(args ChaosArgs, spegelSelector string) ([]measure.ChaosStep, error) {
+	steps := []measure.ChaosStep{}
+	if args.ChaosDeleteSpegelPods < 0 || args.ChaosDeleteSpegelPods > 100 {
+		return nil, errors.New("percentage of Spegel pods to delete has to be between 0 and 100")
+	}
+	if args.ChaosDeleteSpegelPods > 0 {
+		steps = append(steps, measure.ChaosStep{
+			Fault: measure.DeleteSpegelPods{
+				Selector: spegelSelector,
+				Percent:  args.ChaosDeleteSpegelPods,
+			},
+			After: chaosDelay(args.ChaosDeleteSpegelPodsAfter, args.ChaosAfter),
+		})
+	}
+	if args.ChaosDrainNode != "" {
+		steps = append(steps, measure.ChaosStep{
+			Fault: &measure.DrainNode{
+				NodeName:       args.ChaosDrainNode,
+				SpegelSelector: spegelSelector,
+			},
+			After: chaosDelay(args.ChaosDrainNodeAfter, args.ChaosAfter),
+		})
+	}
+	return steps, nil
+}
+
+func chaosDelay(after *time.Duration, defaultAfter time.Duration) time.Duration {
+	if after == nil {
+		return defaultAfter
+	}
+	return *after
+}